@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/url"
+	"strings"
+)
+
+// STREAM_PATH is the only endpoint that takes the token as a query param
+const STREAM_PATH = "/api/v1/logs/stream"
+
+var errUnauthorized = errors.New("missing or invalid access token")
+
+// requireToken rejects requests without one of the configured auth tokens, if there are any.
+// the token goes into an Authorization: Bearer header. EventSource and browser WebSockets can't set headers,
+// so the stream endpoint takes the access_token query param as well
+func requireToken(c *gin.Context) {
+	tokens := currentConfig().Auth.Tokens
+	if len(tokens) == 0 {
+		return
+	}
+
+	token := ""
+	if c.Request.URL.Path == STREAM_PATH {
+		token = c.Query("access_token")
+	}
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		token = strings.TrimPrefix(header, "Bearer ")
+	}
+
+	// compare against every token so the time taken doesn't tell which one was close
+	valid := 0
+	for _, candidate := range tokens {
+		valid |= subtle.ConstantTimeCompare([]byte(token), []byte(candidate))
+	}
+	if token == "" || valid == 0 {
+		c.Header("WWW-Authenticate", "Bearer")
+		abortWithError(c, errUnauthorized)
+	}
+}
+
+// requestLogger is gin's logger with the access_token query param left out of the logged path
+func requestLogger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			redactToken(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// redactToken replaces the value of the access_token query param in path, the whole query if it can't be parsed
+func redactToken(path string) string {
+	route, query, found := strings.Cut(path, "?")
+	if !found {
+		return path
+	}
+
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return route + "?REDACTED"
+	}
+	if !values.Has("access_token") {
+		return path
+	}
+	values.Set("access_token", "REDACTED")
+	return route + "?" + values.Encode()
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"cribl/logmonitor/file"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// serverConfig is everything that differs between deployments.
+// it's read from a yaml file (-config or LOGMONITOR_CONFIG), then overridden by LOGMONITOR_* env vars and then by flags
+type serverConfig struct {
+	Listen          string     `yaml:"listen"`
+	Roots           file.Roots `yaml:"roots"`
+	DefaultFilename string     `yaml:"default_filename"`
+	DefaultSize     int        `yaml:"default_size"`
+	// MaxSize is the largest size (limit for /api/v1/files) an endpoint accepts, see defaultMaxSizes
+	MaxSize map[string]int `yaml:"max_size"`
+	// MaxContext is the largest before, after and context
+	MaxContext int `yaml:"max_context"`
+	// TimestampLocation is the time zone of the timestamps without one (UTC, Local, Europe/Berlin...), see file.TimestampLocation
+	TimestampLocation string `yaml:"timestamp_location"`
+	// CursorSecret signs the pagination cursors handed out by /api/v1/logs/page
+	// without it a random secret is generated and cursors issued before a restart are rejected
+	CursorSecret string             `yaml:"cursor_secret"`
+	Buffers      bufferConfig       `yaml:"buffers"`
+	Limits       limitConfig        `yaml:"limits"`
+	LineIndex    lineIndexConfig    `yaml:"line_index"`
+	KeywordIndex keywordIndexConfig `yaml:"keyword_index"`
+	TLS          tlsConfig          `yaml:"tls"`
+	Auth         authConfig         `yaml:"auth"`
+
+	// secret is CursorSecret, or the random one generated in its place
+	secret []byte
+}
+
+type bufferConfig struct {
+	ReadBufferSize int `yaml:"read_buffer_size"`
+	ChunkSize      int `yaml:"chunk_size"`
+	// Reader is how files are read, file (pread) or mmap
+	Reader string `yaml:"reader"`
+}
+
+type limitConfig struct {
+	MaxLineLength int `yaml:"max_line_length"`
+	// MaxResponseBytes caps the bytes of the lines of a response on top of the size, 0 for no cap
+	MaxResponseBytes int   `yaml:"max_response_bytes"`
+	ParallelWorkers  int   `yaml:"parallel_workers"`
+	ParallelMemory   int64 `yaml:"parallel_memory"`
+}
+
+type lineIndexConfig struct {
+	// Dir is where the indexes are saved, no dir means no indexing
+	Dir      string `yaml:"dir"`
+	Interval int    `yaml:"interval"`
+}
+
+type keywordIndexConfig struct {
+	// Dir is where the indexes are saved, no dir means no keyword indexing
+	Dir string `yaml:"dir"`
+	// Files are the files to index, glob patterns like the filename param
+	Files []string `yaml:"files"`
+	// IntervalSeconds is the time between two runs of the indexer
+	IntervalSeconds int `yaml:"interval_seconds"`
+}
+
+type tlsConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+type authConfig struct {
+	// Tokens are the bearer tokens accepted by the api, any of them will do. no tokens means no auth
+	Tokens []string `yaml:"tokens"`
+}
+
+// defaultMaxSizes are the largest sizes the endpoints accept unless max_size says otherwise
+var defaultMaxSizes = map[string]int{
+	"logs":     10000,
+	"plogs":    10000,
+	"page":     10000,
+	"stream":   10000,
+	"patterns": 1000000,
+	"files":    1000,
+	// files filename can match
+	"glob": 100,
+	// megabytes, see file.SetMaxDecompressedSize
+	"decompressed": file.DEFAULT_MAX_DECOMPRESSED_SIZE >> 20,
+}
+
+// defaultConfig is what the server runs with when nothing is configured
+func defaultConfig() *serverConfig {
+	return &serverConfig{
+		Listen:            "localhost:8080",
+		Roots:             file.Roots{{Path: FILE_PATH}},
+		DefaultFilename:   "var5MB.txt",
+		DefaultSize:       100,
+		MaxContext:        1000,
+		TimestampLocation: "UTC",
+		Buffers: bufferConfig{
+			ReadBufferSize: file.READ_BUFFER_SIZE,
+			ChunkSize:      file.FILE_OFFSET_UNIT_SIZE,
+			Reader:         string(file.DefaultReaderBackend),
+		},
+		Limits: limitConfig{
+			MaxLineLength:    file.MaxLineLength,
+			MaxResponseBytes: 16 << 20,
+			ParallelWorkers:  file.ParallelWorkers,
+			ParallelMemory:   file.ParallelMemoryBudget,
+		},
+		LineIndex: lineIndexConfig{
+			Interval: file.LineIndexInterval,
+		},
+		KeywordIndex: keywordIndexConfig{
+			IntervalSeconds: 60,
+		},
+	}
+}
+
+// setting is a config value that can be overridden by an env var and a flag
+type setting struct {
+	env string
+	// flag is empty for secrets, they'd show up in the process list
+	flag  string
+	usage string
+	set   func(config *serverConfig, value string) error
+}
+
+func stringSetting(field func(config *serverConfig) *string) func(*serverConfig, string) error {
+	return func(config *serverConfig, value string) error {
+		*field(config) = value
+		return nil
+	}
+}
+
+func intSetting(field func(config *serverConfig) *int) func(*serverConfig, string) error {
+	return func(config *serverConfig, value string) error {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(config) = number
+		return nil
+	}
+}
+
+var settings = []setting{
+	{"LOGMONITOR_LISTEN", "listen", "address to listen on, host:port",
+		stringSetting(func(config *serverConfig) *string { return &config.Listen })},
+	{"LOGMONITOR_ROOTS", "roots", `json list of log roots, like [{"path": "/var/log", "allow": ["*.log"], "deny": ["secure*"]}]`,
+		func(config *serverConfig, value string) error {
+			roots := file.Roots{}
+			if err := json.Unmarshal([]byte(value), &roots); err != nil {
+				return err
+			}
+			config.Roots = roots
+			return nil
+		}},
+	{"LOGMONITOR_DEFAULT_FILENAME", "default-filename", "file read when a request has no filename",
+		stringSetting(func(config *serverConfig) *string { return &config.DefaultFilename })},
+	{"LOGMONITOR_DEFAULT_SIZE", "default-size", "lines returned when a request has no size",
+		intSetting(func(config *serverConfig) *int { return &config.DefaultSize })},
+	{"LOGMONITOR_MAX_SIZE", "max-size", "largest size per endpoint, like logs=10000,page=500",
+		func(config *serverConfig, value string) error {
+			sizes := map[string]int{}
+			for endpoint, size := range config.MaxSize {
+				sizes[endpoint] = size
+			}
+			for _, pair := range strings.Split(value, ",") {
+				endpoint, size, found := strings.Cut(pair, "=")
+				number, err := strconv.Atoi(size)
+				if !found || err != nil {
+					return fmt.Errorf("%q is not endpoint=size", pair)
+				}
+				sizes[strings.TrimSpace(endpoint)] = number
+			}
+			config.MaxSize = sizes
+			return nil
+		}},
+	{"LOGMONITOR_MAX_CONTEXT", "max-context", "largest before, after and context",
+		intSetting(func(config *serverConfig) *int { return &config.MaxContext })},
+	{"LOGMONITOR_TIMESTAMP_LOCATION", "timestamp-location", "time zone of the timestamps without one, like UTC, Local or Europe/Berlin",
+		stringSetting(func(config *serverConfig) *string { return &config.TimestampLocation })},
+	{"LOGMONITOR_CURSOR_SECRET", "", "",
+		stringSetting(func(config *serverConfig) *string { return &config.CursorSecret })},
+	{"LOGMONITOR_READ_BUFFER_SIZE", "read-buffer-size", "bytes read from a file at once",
+		intSetting(func(config *serverConfig) *int { return &config.Buffers.ReadBufferSize })},
+	{"LOGMONITOR_CHUNK_SIZE", "chunk-size", "bytes of a file each /api/v1/plogs worker reads at once",
+		intSetting(func(config *serverConfig) *int { return &config.Buffers.ChunkSize })},
+	{"LOGMONITOR_READER", "reader", "how files are read, file or mmap",
+		stringSetting(func(config *serverConfig) *string { return &config.Buffers.Reader })},
+	{"LOGMONITOR_MAX_LINE_LENGTH", "max-line-length", "bytes after which a line is truncated",
+		intSetting(func(config *serverConfig) *int { return &config.Limits.MaxLineLength })},
+	{"LOGMONITOR_MAX_RESPONSE_BYTES", "max-response-bytes", "bytes of lines a response holds at most, 0 for no limit",
+		intSetting(func(config *serverConfig) *int { return &config.Limits.MaxResponseBytes })},
+	{"LOGMONITOR_PARALLEL_WORKERS", "parallel-workers", "chunks /api/v1/plogs reads at the same time",
+		intSetting(func(config *serverConfig) *int { return &config.Limits.ParallelWorkers })},
+	{"LOGMONITOR_PARALLEL_MEMORY", "parallel-memory", "bytes of chunks /api/v1/plogs holds in memory at once",
+		func(config *serverConfig, value string) error {
+			budget, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			config.Limits.ParallelMemory = budget
+			return nil
+		}},
+	{"LOGMONITOR_LINE_INDEX_DIR", "line-index-dir", "directory to keep line indexes in, indexing is off without it",
+		stringSetting(func(config *serverConfig) *string { return &config.LineIndex.Dir })},
+	{"LOGMONITOR_LINE_INDEX_INTERVAL", "line-index-interval", "the index remembers where every this many lines start",
+		intSetting(func(config *serverConfig) *int { return &config.LineIndex.Interval })},
+	{"LOGMONITOR_KEYWORD_INDEX_DIR", "keyword-index-dir", "directory to keep keyword indexes in, keyword indexing is off without it",
+		stringSetting(func(config *serverConfig) *string { return &config.KeywordIndex.Dir })},
+	{"LOGMONITOR_KEYWORD_INDEX_FILES", "keyword-index-files", "comma separated glob patterns of the files to keep a keyword index of",
+		func(config *serverConfig, value string) error {
+			config.KeywordIndex.Files = strings.Split(value, ",")
+			return nil
+		}},
+	{"LOGMONITOR_KEYWORD_INDEX_INTERVAL_SECONDS", "keyword-index-interval-seconds", "seconds between two runs of the keyword indexer",
+		intSetting(func(config *serverConfig) *int { return &config.KeywordIndex.IntervalSeconds })},
+	{"LOGMONITOR_TLS_CERT", "tls-cert", "certificate file, serves https together with -tls-key",
+		stringSetting(func(config *serverConfig) *string { return &config.TLS.CertFile })},
+	{"LOGMONITOR_TLS_KEY", "tls-key", "private key file of -tls-cert",
+		stringSetting(func(config *serverConfig) *string { return &config.TLS.KeyFile })},
+	{"LOGMONITOR_AUTH_TOKENS", "", "",
+		func(config *serverConfig, value string) error {
+			config.Auth.Tokens = strings.Split(value, ",")
+			return nil
+		}},
+}
+
+// loadConfig builds the config from the defaults, the config file, the env and the command line args, and validates it
+func loadConfig(args []string) (*serverConfig, error) {
+	flags := flag.NewFlagSet("logmonitor", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("LOGMONITOR_CONFIG"), "yaml config file (LOGMONITOR_CONFIG)")
+
+	// flags are applied last, after the config file and the env
+	type override struct {
+		setting setting
+		value   string
+	}
+	overrides := []override{}
+	for _, s := range settings {
+		if s.flag == "" {
+			continue
+		}
+		s := s
+		flags.Func(s.flag, fmt.Sprintf("%s (%s)", s.usage, s.env), func(value string) error {
+			overrides = append(overrides, override{s, value})
+			return nil
+		})
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := defaultConfig()
+	if *configFile != "" {
+		content, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		// a typo in a key shouldn't silently fall back to the default
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config file %s: %w", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := s.set(config, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, o := range overrides {
+		if err := o.setting.set(config, o.value); err != nil {
+			return nil, fmt.Errorf("-%s: %w", o.setting.flag, err)
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	config.secret = []byte(config.CursorSecret)
+	if config.CursorSecret == "" {
+		config.secret = make([]byte, 32)
+		if _, err := rand.Read(config.secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// validate checks the config as a whole, so the server doesn't start (or reload) with settings it would fail on later
+func (config *serverConfig) validate() error {
+	if _, _, err := net.SplitHostPort(config.Listen); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+
+	if len(config.Roots) == 0 {
+		return errors.New("roots: no roots configured")
+	}
+	for _, root := range config.Roots {
+		info, err := os.Stat(root.Path)
+		if err != nil {
+			return fmt.Errorf("roots: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("roots: %s is not a directory", root.Path)
+		}
+
+		for _, pattern := range append(append([]string{}, root.Allow...), root.Deny...) {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return fmt.Errorf("roots: %s: pattern %q: %w", root.Path, pattern, err)
+			}
+		}
+	}
+
+	if config.DefaultFilename == "" {
+		return errors.New("default_filename: must not be empty")
+	}
+	if config.DefaultSize <= 0 {
+		return fmt.Errorf("default_size: must be positive, got %d", config.DefaultSize)
+	}
+	for endpoint, size := range config.MaxSize {
+		if _, ok := defaultMaxSizes[endpoint]; !ok {
+			return fmt.Errorf("max_size: unknown endpoint %q", endpoint)
+		}
+		if size <= 0 {
+			return fmt.Errorf("max_size.%s: must be positive, got %d", endpoint, size)
+		}
+	}
+	if config.MaxContext < 0 {
+		return fmt.Errorf("max_context: must not be negative, got %d", config.MaxContext)
+	}
+	if _, err := time.LoadLocation(config.TimestampLocation); err != nil {
+		return fmt.Errorf("timestamp_location: %w", err)
+	}
+
+	if config.Buffers.ReadBufferSize <= 0 {
+		return fmt.Errorf("buffers.read_buffer_size: must be positive, got %d", config.Buffers.ReadBufferSize)
+	}
+	if config.Buffers.ChunkSize <= 0 {
+		return fmt.Errorf("buffers.chunk_size: must be positive, got %d", config.Buffers.ChunkSize)
+	}
+	switch file.ReaderBackend(config.Buffers.Reader) {
+	case file.ReaderBackendFile, file.ReaderBackendMmap:
+	default:
+		return fmt.Errorf("buffers.reader: must be file or mmap, got %q", config.Buffers.Reader)
+	}
+	if config.Limits.MaxLineLength <= 0 {
+		return fmt.Errorf("limits.max_line_length: must be positive, got %d", config.Limits.MaxLineLength)
+	}
+	if config.Limits.MaxResponseBytes < 0 {
+		return fmt.Errorf("limits.max_response_bytes: must not be negative, got %d", config.Limits.MaxResponseBytes)
+	}
+	if config.Limits.ParallelWorkers < 1 {
+		return fmt.Errorf("limits.parallel_workers: must be positive, got %d", config.Limits.ParallelWorkers)
+	}
+	if config.Limits.ParallelMemory < int64(config.Buffers.ChunkSize) {
+		return fmt.Errorf("limits.parallel_memory: must be at least buffers.chunk_size (%d bytes), got %d",
+			config.Buffers.ChunkSize, config.Limits.ParallelMemory)
+	}
+
+	if config.LineIndex.Dir != "" {
+		info, err := os.Stat(config.LineIndex.Dir)
+		if err != nil {
+			return fmt.Errorf("line_index.dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("line_index.dir: %s is not a directory", config.LineIndex.Dir)
+		}
+	}
+	if config.LineIndex.Interval <= 0 {
+		return fmt.Errorf("line_index.interval: must be positive, got %d", config.LineIndex.Interval)
+	}
+
+	if config.KeywordIndex.Dir != "" {
+		info, err := os.Stat(config.KeywordIndex.Dir)
+		if err != nil {
+			return fmt.Errorf("keyword_index.dir: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("keyword_index.dir: %s is not a directory", config.KeywordIndex.Dir)
+		}
+	}
+	if len(config.KeywordIndex.Files) > 0 && config.KeywordIndex.Dir == "" {
+		return errors.New("keyword_index.files: needs keyword_index.dir")
+	}
+	for _, pattern := range config.KeywordIndex.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil || !filepath.IsLocal(pattern) {
+			return fmt.Errorf("keyword_index.files: invalid pattern %q", pattern)
+		}
+	}
+	if config.KeywordIndex.IntervalSeconds <= 0 {
+		return fmt.Errorf("keyword_index.interval_seconds: must be positive, got %d", config.KeywordIndex.IntervalSeconds)
+	}
+
+	if (config.TLS.CertFile == "") != (config.TLS.KeyFile == "") {
+		return errors.New("tls: cert_file and key_file have to be set together")
+	}
+	if config.TLS.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+	}
+
+	for _, token := range config.Auth.Tokens {
+		if token == "" {
+			return errors.New("auth.tokens: empty token")
+		}
+	}
+
+	return nil
+}
+
+// applyLimits hands the buffer sizes, limits, index settings and timestamp location to the file package.
+// they're package vars that are read without locking, so this only happens once before the server starts
+func (config *serverConfig) applyLimits() {
+	file.ReadBufferSize = config.Buffers.ReadBufferSize
+	file.ParallelChunkSize = config.Buffers.ChunkSize
+	file.DefaultReaderBackend = file.ReaderBackend(config.Buffers.Reader)
+	file.MaxLineLength = config.Limits.MaxLineLength
+	file.MaxResultBytes = config.Limits.MaxResponseBytes
+	file.ParallelWorkers = config.Limits.ParallelWorkers
+	file.ParallelMemoryBudget = config.Limits.ParallelMemory
+	file.LineIndexDir = config.LineIndex.Dir
+	file.LineIndexInterval = config.LineIndex.Interval
+	file.KeywordIndexDir = config.KeywordIndex.Dir
+	// validate has loaded it already
+	file.TimestampLocation, _ = time.LoadLocation(config.TimestampLocation)
+}
+
+// applyMaxSizes hands the max_size entries the file package needs to it, on startup and on reload
+func (config *serverConfig) applyMaxSizes() {
+	file.SetMaxDecompressedSize(int64(config.maxSize("decompressed")) << 20)
+}
+
+// maxSize is the largest size endpoint accepts
+func (config *serverConfig) maxSize(endpoint string) int {
+	if size, ok := config.MaxSize[endpoint]; ok {
+		return size
+	}
+	return defaultMaxSizes[endpoint]
+}
+
+var activeConfig atomic.Pointer[serverConfig]
+
+// currentConfig is the config requests are served with, it's replaced on SIGHUP
+// a request should call it once and stick to what it got
+func currentConfig() *serverConfig {
+	return activeConfig.Load()
+}
+
+// reloadOnHangup reloads the config on every SIGHUP.
+// an invalid config is logged and the running one is kept.
+// the listen address, tls, buffers, limits, timestamp location, line index and keyword index dir only take effect on a restart,
+// everything else applies to the next request (or the next run of the keyword indexer)
+func reloadOnHangup(args []string) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+
+	for range hangups {
+		next, err := loadConfig(args)
+		if err != nil {
+			log.Printf("config: reload failed, keeping the running config: %v", err)
+			continue
+		}
+
+		current := currentConfig()
+		if next.Listen != current.Listen || next.TLS != current.TLS ||
+			next.Buffers != current.Buffers || next.Limits != current.Limits || next.LineIndex != current.LineIndex ||
+			next.TimestampLocation != current.TimestampLocation || next.KeywordIndex.Dir != current.KeywordIndex.Dir {
+			log.Printf("config: listen, tls, buffers, limits, timestamp_location, line_index and keyword_index.dir changes need a restart, keeping the running ones")
+		}
+		next.Listen, next.TLS, next.Buffers, next.Limits = current.Listen, current.TLS, current.Buffers, current.Limits
+		next.TimestampLocation = current.TimestampLocation
+		next.LineIndex, next.KeywordIndex.Dir = current.LineIndex, current.KeywordIndex.Dir
+
+		// don't invalidate the cursors handed out so far by generating another random secret
+		if next.CursorSecret == "" && current.CursorSecret == "" {
+			next.secret = current.secret
+		}
+
+		activeConfig.Store(next)
+		next.applyMaxSizes()
+		log.Printf("config: reloaded")
+	}
+}
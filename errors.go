@@ -0,0 +1,68 @@
+package main
+
+import (
+	"cribl/logmonitor/file"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+var errInvalidParameter = errors.New("invalid query parameter")
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Position and Marker point at syntax errors in the q param
+	Position *int   `json:"position,omitempty"`
+	Marker   string `json:"marker,omitempty"`
+}
+
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+// errorStatuses maps the errors handlers can run into to a http status and a machine readable code
+// anything not listed here is a 500
+var errorStatuses = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{file.ErrFileNotFound, http.StatusNotFound, "file_not_found"},
+	{file.ErrPermissionDenied, http.StatusForbidden, "permission_denied"},
+	{file.ErrPathNotAllowed, http.StatusForbidden, "path_not_allowed"},
+	{file.ErrOffsetNotAtLineBoundary, http.StatusBadRequest, "offset_not_at_line_boundary"},
+	{file.ErrLineTooLong, http.StatusRequestEntityTooLarge, "line_too_long"},
+	{file.ErrCompressedForward, http.StatusBadRequest, "compressed_forward"},
+	{file.ErrDecompressedTooLarge, http.StatusRequestEntityTooLarge, "decompressed_too_large"},
+	{file.ErrInvalidCursor, http.StatusBadRequest, "invalid_cursor"},
+	{file.ErrCursorFileChanged, http.StatusConflict, "file_changed"},
+	{file.ErrInvalidQuery, http.StatusBadRequest, "invalid_query"},
+	{file.ErrTooManyBuckets, http.StatusBadRequest, "too_many_buckets"},
+	{errInvalidParameter, http.StatusBadRequest, "invalid_parameter"},
+	{errUnauthorized, http.StatusUnauthorized, "unauthorized"},
+}
+
+// abortWithError records err on the context and aborts the request with a structured json error body
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+
+	status, code := http.StatusInternalServerError, "internal_error"
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.err) {
+			status, code = e.status, e.code
+			break
+		}
+	}
+
+	// the full path only goes into the log
+	body := errorBody{Code: code, Message: currentConfig().Roots.RelativeMessage(err.Error())}
+	queryErr := &file.QueryError{}
+	if errors.As(err, &queryErr) {
+		body.Position = &queryErr.Position
+		body.Marker = queryErr.Marker()
+	}
+
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.AbortWithStatusJSON(status, errorResponse{Error: body})
+}
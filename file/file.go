@@ -2,59 +2,72 @@ package file
 
 import (
 	"bytes"
-	"os"
-	"strings"
+	"fmt"
+	"io"
 )
 
 // ReadLastLinesWithOffset reads the last initBufSize bytes in front of the fileOffset bytes before EOF
 // fileOffset needs to be at a line break. otherwise the incomplete line at the end of the buffer will be lost
 // returns the complete lines in reverse order, a new offset for the next call and an error if any
-// Note: the initBufSize needs to be longer than the maximum length of a log line
+// Note: if a log line is longer than initBufSize, the buffer grows until the line fits (see readLastLines)
 func ReadLastLinesWithOffset(fileName string, fileOffset int64, initBufSize int) ([]string, int64, error) {
-	file, err := os.Open(fileName)
+	lines, fileSize, err := readLastLines(fileName, fileOffset, initBufSize)
 	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	stat, err := os.Stat(fileName)
-	if err != nil {
-		panic(err)
+		return nil, 0, err
 	}
 
-	fileSize := stat.Size()
 	// the fileOffset has exceeded the size of the file,
 	// i.e., we've scanned through the whole file
-	if fileSize <= fileOffset {
+	if len(lines) == 0 {
 		return []string{}, fileSize, nil
 	}
 
-	curBufStart := fileSize - fileOffset - int64(initBufSize)
-	bufSize := initBufSize
-
-	if fileSize-fileOffset < int64(initBufSize) {
-		// the remainder of the file is not big enough for a full buffer size
-		curBufStart = 0
-		bufSize = int(fileSize - fileOffset)
+	lastLines := make([]string, len(lines))
+	for i, line := range lines {
+		lastLines[i] = line.Line
 	}
 
-	buf := make([]byte, bufSize)
-	// ReadAt can't start from the very beginning of a file
-	if curBufStart == 0 {
-		_, err = file.Read(buf)
-	} else {
-		_, err = file.ReadAt(buf, curBufStart)
-	}
+	// the new offset is the start of the earliest line we've read
+	// which will be the end location of next round of buffer reading
+	return lastLines, lines[len(lines)-1].Offset, nil
+}
+
+// MaxLineLength is the hard limit on the length of a log line (in bytes, without the line break)
+// the read buffer grows up to this size to fit long lines.
+// lines longer than this are cut off at MaxLineLength bytes and get a TRUNCATED_LINE_MARKER appended
+var MaxLineLength = 1 << 20
+
+const TRUNCATED_LINE_MARKER = "...[truncated %d bytes]"
+
+// readLastLines reads the complete lines in the last initBufSize bytes in front of the fileOffset bytes before EOF
+// returns the lines in reverse order along with the offset of each line's start from EOF, and the file size
+// fileOffset needs to be at a line break.
+// if the buffer doesn't hold a complete line, it is doubled until it does or it reaches MaxLineLength
+func readLastLines(fileName string, fileOffset int64, initBufSize int) ([]LineReturn, int64, error) {
+	file, fileSize, err := openReader(fileName)
 	if err != nil {
-		panic(err)
+		return nil, 0, err
 	}
+	defer file.Close()
+
+	lines, err := readLastLinesFrom(file, fileSize, fileOffset, initBufSize)
+	return lines, fileSize, err
+}
 
-	// validate that the input fileOffset value is correct
-	if buf[len(buf)-1] != '\n' {
-		panic("the last byte in the buffer is not a line break")
+// readLastLinesFrom works like readLastLines on an already opened file.
+// fileSize doesn't need to be the current size of the file, offsets are counted back from fileSize.
+// this way a caller can pin the end of a file that is still being appended to
+func readLastLinesFrom(file Reader, fileSize int64, fileOffset int64, initBufSize int) ([]LineReturn, error) {
+	reader := NewBackwardLineReader(file, fileSize)
+	reader.BufferSize = initBufSize
+	if _, err := reader.Seek(-fileOffset, io.SeekEnd); err != nil {
+		return nil, err
 	}
+	return reader.nextBuffer()
+}
 
-	// find all the line break's locations (their index within the buffer)
+// lineBreakIndices finds all the line break's locations (their index within the buffer)
+func lineBreakIndices(buf []byte) []int64 {
 	offset := 0
 	indices := []int64{}
 	for {
@@ -68,63 +81,102 @@ func ReadLastLinesWithOffset(fileName string, fileOffset int64, initBufSize int)
 		offset += index + 1
 	}
 
-	lastLines := []string{}
-	for i := 0; i < len(indices)-1; i++ {
-		// between two adjacent line breaks is a complete line
-		endLineBreakIndex := indices[len(indices)-1-i]
-		startLineBreakIndex := indices[len(indices)-2-i]
+	return indices
+}
+
+// TrimLineBreak drops the \n or \r\n at the end of line, if there is one
+func TrimLineBreak(line []byte) []byte {
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte{'\n'}), []byte{'\r'})
+}
 
-		lastLines = append(lastLines, string(buf[startLineBreakIndex+1:endLineBreakIndex]))
+// truncateLine cuts off the line at MaxLineLength bytes if it is longer than that
+func truncateLine(line []byte) string {
+	if len(line) <= MaxLineLength {
+		return string(line)
 	}
 
-	// if this is the beginning of the file, append the first line
-	// which starts at the beginning of the buffer and ends at the first line break
-	if curBufStart == 0 {
-		lastLines = append(lastLines, string(buf[:indices[0]]))
-		// set fileOffset to be file size, indicating we've scanned through
-		return lastLines, fileSize, nil
+	return string(line[:MaxLineLength]) + fmt.Sprintf(TRUNCATED_LINE_MARKER, len(line)-MaxLineLength)
+}
+
+// truncateLinePrefix is truncateLine for a line that is length bytes long, of which only the beginning has been kept.
+// the prefix has to be the whole line or longer than MaxLineLength
+func truncateLinePrefix(prefix []byte, length int64) string {
+	if length <= int64(MaxLineLength) {
+		return string(prefix)
 	}
 
-	// the new offset indicates the first line break's location from the end of the file
-	// which will be the end location of next round of buffer reading
-	return lastLines, int64(bufSize) - indices[0] - 1 + fileOffset, nil
+	return string(prefix[:MaxLineLength]) + fmt.Sprintf(TRUNCATED_LINE_MARKER, length-int64(MaxLineLength))
+}
+
+// readTruncatedLine returns the line ending at the line break lineEnd (an absolute position in the file)
+// cut off at MaxLineLength bytes. used for lines that don't fit into the read buffer
+func readTruncatedLine(file Reader, fileSize int64, lineEnd int64) (LineReturn, error) {
+	// look backwards for the line break in front of the line, chunk by chunk
+	lineStart := int64(0)
+	chunk := make([]byte, ReadBufferSize)
+	for chunkEnd := lineEnd - int64(MaxLineLength); chunkEnd > 0; chunkEnd -= int64(len(chunk)) {
+		chunkStart := chunkEnd - int64(len(chunk))
+		if chunkStart < 0 {
+			chunkStart = 0
+		}
+
+		if err := readBufferAt(file, chunk[:chunkEnd-chunkStart], chunkStart); err != nil {
+			return LineReturn{}, err
+		}
+
+		if index := bytes.LastIndexByte(chunk[:chunkEnd-chunkStart], '\n'); index != -1 {
+			lineStart = chunkStart + int64(index) + 1
+			break
+		}
+	}
+
+	head := make([]byte, MaxLineLength)
+	if err := readBufferAt(file, head, lineStart); err != nil {
+		return LineReturn{}, err
+	}
+
+	return LineReturn{
+		Line:   string(head) + fmt.Sprintf(TRUNCATED_LINE_MARKER, lineEnd-lineStart-int64(MaxLineLength)),
+		Offset: fileSize - lineStart,
+	}, nil
 }
 
 // Each time we read from the end of the file a buffer of size 32KB
-// if log lines are longer than 32KB, the program won't work
-// this is because we're only returning full log lines during each round
-// if the log line is longer than 32KB, then the buffer only contains a segmented line which
-// will be discarded during the current round and attempted again and again (into infinite loop)
+// if a log line is longer than 32KB, the buffer only contains a segmented line.
+// in that case the buffer is grown for that read until the line fits, up to MaxLineLength
 const READ_BUFFER_SIZE = 1 << 15
 
+// ReadBufferSize is the buffer size the readers actually use, READ_BUFFER_SIZE unless configured otherwise.
+// set it before serving any requests, it's not safe to change while files are being read
+var ReadBufferSize = READ_BUFFER_SIZE
+
 // ReadLastNLinesWithKeyword keeps calling ReadLastLinesWithOffset until we reach the target lines of log.
 // if input query is not empty, log lines are filtered first before they are appended
 func ReadLastNLinesWithKeyword(fileName string, n int, query string) ([]string, error) {
-	initBufSize := READ_BUFFER_SIZE
-	lines := []string{}
-	var fileOffset int64 = 0
-	newlines := []string{"dummy"}
-	var err error
-	for len(lines) < n && len(newlines) != 0 {
-		newlines, fileOffset, err = ReadLastLinesWithOffset(fileName, fileOffset, initBufSize)
-		if err != nil {
-			panic(err)
-		}
+	return ReadLastNLinesMatching(fileName, n, KeywordMatcher(query))
+}
 
-		if query == "" {
-			lines = append(lines, newlines...)
-		} else {
-			for _, newline := range newlines {
-				if strings.Contains(newline, query) {
-					lines = append(lines, newline)
-				}
-			}
+// ReadLastNLinesMatching reads the file backwards (see BackwardLineReader) until we reach n lines that matcher matches.
+// the lines appended while reading aren't read.
+// compressed files are decompressed from the start instead (see collectLastLinesCompressed)
+func ReadLastNLinesMatching(fileName string, n int, matcher Matcher) ([]string, error) {
+	if isCompressed(fileName) {
+		lines, _, _, err := collectLastLinesCompressed(fileName, n, matcher, 0, newResultBudget())
+		if err != nil {
+			return nil, err
 		}
+		return lineStrings(lines), nil
 	}
 
-	if len(lines) >= n {
-		return lines[:n], nil
+	reader, err := OpenBackwardLineReader(fileName)
+	if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
 
-	return lines, nil
+	lines, _, err := collectBackwardLines(reader, n, matcher, newResultBudget())
+	if err != nil {
+		return nil, err
+	}
+	return lineStrings(lines), nil
 }
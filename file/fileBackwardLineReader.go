@@ -0,0 +1,261 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// BackwardLineReader reads the lines of a file newest first, one at a time, keeping the file open in between.
+// it reads a buffer at a time and grows the buffer for a line that doesn't fit, up to MaxLineLength
+// (longer lines are cut off, see TRUNCATED_LINE_MARKER).
+// a line ends with \n or \r\n, neither is part of the line. the bytes behind the last line break of the file are a line too.
+// offsets are counted back from the size the reader was made with, like LineReturn.Offset,
+// what's appended to the file after that isn't read
+type BackwardLineReader struct {
+	// BufferSize is how many bytes are read at once, ReadBufferSize unless changed before the first Next
+	BufferSize int
+
+	file Reader
+	// owned is true when the reader opened the file itself and Close closes it
+	owned bool
+	size  int64
+	// offset is where the line returned last starts (or the offset Seek moved to), the next line ends there
+	offset int64
+	// the lines of the last buffer that haven't been returned yet, newest first
+	lines []backwardLine
+}
+
+type backwardLine struct {
+	line   []byte
+	offset int64
+}
+
+// OpenBackwardLineReader opens fileName with DefaultReaderBackend and reads it from the end, Close closes the file
+func OpenBackwardLineReader(fileName string) (*BackwardLineReader, error) {
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := NewBackwardLineReader(file, fileSize)
+	reader.owned = true
+	return reader, nil
+}
+
+// NewBackwardLineReader reads the lines of file in front of fileSize, which doesn't need to be its current size.
+// this way a caller can pin the end of a file that is still being appended to.
+// the file stays the caller's, Close leaves it open
+func NewBackwardLineReader(file Reader, fileSize int64) *BackwardLineReader {
+	return &BackwardLineReader{BufferSize: ReadBufferSize, file: file, size: fileSize}
+}
+
+// Next returns the next older line and the offset it starts at, io.EOF once the start of the file has been reached.
+// ErrOffsetNotAtLineBoundary means Seek moved into the middle of a line, ErrLineTooLong into the middle of one longer than MaxLineLength.
+// the line points into the reader's buffer, or into the file itself with ReaderBackendMmap,
+// it's only valid until the next call to Next or Seek and must not be changed.
+// with mmap a file truncated under the reader can fault when the line is touched, the file backend can't
+func (reader *BackwardLineReader) Next() ([]byte, int64, error) {
+	if len(reader.lines) == 0 {
+		if reader.offset >= reader.size {
+			return nil, reader.offset, io.EOF
+		}
+
+		err := guardFaults(reader.file, func() error {
+			var err error
+			reader.lines, err = reader.readBuffer()
+			return err
+		})
+		if err != nil {
+			return nil, reader.offset, err
+		}
+	}
+
+	next := reader.lines[0]
+	reader.lines = reader.lines[1:]
+	reader.offset = next.offset
+	return next.line, next.offset, nil
+}
+
+// Seek moves the reader like io.Seeker, the next line is the one ending at the new position (counted from the start of the file).
+// to carry on from a LineReturn.Offset, which is counted back from the end, seek to -offset from io.SeekEnd.
+// a position in front of the start of the file makes Next return io.EOF
+func (reader *BackwardLineReader) Seek(offset int64, whence int) (int64, error) {
+	position := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		position += reader.size - reader.offset
+	case io.SeekEnd:
+		position += reader.size
+	default:
+		return 0, fmt.Errorf("seek: invalid whence %d", whence)
+	}
+	if position > reader.size {
+		return 0, ErrOffsetNotAtLineBoundary
+	}
+
+	reader.offset = reader.size - position
+	reader.lines = nil
+	return position, nil
+}
+
+// Offset is where the line Next returned last starts, counted back from the end like LineReturn.Offset.
+// seek to -Offset from io.SeekEnd to carry on from there later
+func (reader *BackwardLineReader) Offset() int64 {
+	return reader.offset
+}
+
+// Size is the size of the file the offsets are counted back from
+func (reader *BackwardLineReader) Size() int64 {
+	return reader.size
+}
+
+// Close closes the file if the reader opened it
+func (reader *BackwardLineReader) Close() error {
+	reader.lines = nil
+	if reader.owned {
+		return reader.file.Close()
+	}
+	return nil
+}
+
+// nextBuffer returns the lines of the next buffer as LineReturns, newest first, or no lines at the start of the file
+func (reader *BackwardLineReader) nextBuffer() ([]LineReturn, error) {
+	lines := []LineReturn{}
+	err := guardFaults(reader.file, func() error {
+		for len(lines) == 0 || len(reader.lines) > 0 {
+			line, offset, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			lines = append(lines, LineReturn{Line: string(line), Offset: offset})
+		}
+		return nil
+	})
+	return lines, err
+}
+
+// readBuffer reads the complete lines in the BufferSize bytes in front of offset
+// if the buffer doesn't hold a complete line, it is doubled until it does or it reaches MaxLineLength
+func (reader *BackwardLineReader) readBuffer() ([]backwardLine, error) {
+	end := reader.size - reader.offset
+	bufSize := reader.BufferSize
+	for {
+		bufStart := end - int64(bufSize)
+		if bufStart < 0 {
+			// the remainder of the file is not big enough for a full buffer size
+			bufStart = 0
+			bufSize = int(end)
+		}
+
+		buf, err := reader.file.Slice(bufStart, end)
+		if err != nil {
+			return nil, err
+		}
+
+		indices := lineBreakIndices(buf)
+		if buf[len(buf)-1] != '\n' {
+			// only the last line of the file can do without a line break
+			if reader.offset != 0 {
+				return nil, boundaryError(reader.file, reader.size, end)
+			}
+			indices = append(indices, int64(len(buf)))
+		}
+
+		// the only line break is the one at the end of the buffer and we're not at the beginning of the file
+		// the line in front of it doesn't fit into the buffer. try again with a bigger one,
+		// a line plus the line breaks on both sides of it needs MaxLineLength + 2 bytes
+		if bufStart != 0 && len(indices) < 2 {
+			if bufSize >= MaxLineLength+2 {
+				line, err := readTruncatedLine(reader.file, reader.size, bufStart+indices[0])
+				if err != nil {
+					return nil, err
+				}
+				return []backwardLine{{line: []byte(line.Line), offset: line.Offset}}, nil
+			}
+
+			bufSize *= 2
+			if bufSize > MaxLineLength+2 {
+				bufSize = MaxLineLength + 2
+			}
+			continue
+		}
+
+		lines := make([]backwardLine, 0, len(indices))
+		for i := len(indices) - 1; i > 0; i-- {
+			// between two adjacent line breaks is a complete line
+			lines = append(lines, backwardLine{
+				line:   cutLine(buf[indices[i-1]+1 : indices[i]]),
+				offset: reader.size - bufStart - indices[i-1] - 1,
+			})
+		}
+
+		// if this is the beginning of the file, append the first line
+		// which starts at the beginning of the buffer and ends at the first line break
+		if bufStart == 0 {
+			lines = append(lines, backwardLine{line: cutLine(buf[:indices[0]]), offset: reader.size})
+		}
+
+		return lines, nil
+	}
+}
+
+// cutLine drops the \r of a \r\n line break and cuts off the line at MaxLineLength bytes if it is longer than that
+func cutLine(line []byte) []byte {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(line) <= MaxLineLength {
+		return line
+	}
+
+	// capped, the marker mustn't be appended in place
+	return append(line[:MaxLineLength:MaxLineLength], fmt.Sprintf(TRUNCATED_LINE_MARKER, len(line)-MaxLineLength)...)
+}
+
+// collectBackwardLines reads lines from reader until we reach n lines that matcher matches
+// or the lines don't fit into budget anymore, like collectLastLines.
+// returns the lines and the offset the next read should start from
+func collectBackwardLines(reader *BackwardLineReader, n int, matcher Matcher, budget *resultBudget) ([]LineReturn, int64, error) {
+	start := reader.Offset()
+	lines := []LineReturn{}
+	full := false
+	err := guardFaults(reader.file, func() error {
+		for len(lines) < n {
+			line, offset, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			text := string(line)
+			if !isMatchAll(matcher) && !matcher.Match(text) {
+				continue
+			}
+			if !budget.take(len(text)) {
+				full = true
+				return nil
+			}
+			lines = append(lines, LineReturn{Line: text, Offset: offset})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// the next read starts behind the last line that fit
+	if full {
+		if len(lines) == 0 {
+			return lines, start, nil
+		}
+		return lines, lines[len(lines)-1].Offset, nil
+	}
+
+	// behind the nth line, or where the scan stopped at the start of the file
+	return lines, reader.Offset(), nil
+}
@@ -0,0 +1,144 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"regexp"
+	"strings"
+)
+
+var _ = Describe("BackwardLineReader", func() {
+	// readAll returns the lines the reader has left, newest first
+	readAll := func(reader *file.BackwardLineReader) []file.LineReturn {
+		lines := []file.LineReturn{}
+		for {
+			line, offset, err := reader.Next()
+			if err == io.EOF {
+				return lines
+			}
+			Expect(err).To(BeNil())
+			lines = append(lines, file.LineReturn{Line: string(line), Offset: offset})
+		}
+	}
+
+	open := func(fileName string) *file.BackwardLineReader {
+		reader, err := file.OpenBackwardLineReader(fileName)
+		Expect(err).To(BeNil())
+		DeferCleanup(reader.Close)
+		return reader
+	}
+
+	It("returns the lines newest first with the offsets of the paginated reader", func() {
+		fileName := writeTestLines(2000)
+		reader := open(fileName)
+		reader.BufferSize = 256
+
+		expected, err := file.ReadLastLinesWithOffsetPagination(fileName, 0, 1<<20)
+		Expect(err).To(BeNil())
+		Expect(readAll(reader)).To(Equal(expected))
+		Expect(reader.Offset()).To(Equal(reader.Size()))
+
+		_, _, err = reader.Next()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("carries on from a LineReturn offset after a seek", func() {
+		fileName := writeTestLines(100)
+		reader := open(fileName)
+
+		lines, err := file.ReadLastLinesWithOffsetPagination(fileName, 0, 1<<20)
+		Expect(err).To(BeNil())
+
+		position, err := reader.Seek(-lines[41].Offset, io.SeekEnd)
+		Expect(err).To(BeNil())
+		Expect(position).To(Equal(reader.Size() - lines[41].Offset))
+		line, offset, err := reader.Next()
+		Expect(err).To(BeNil())
+		Expect(string(line)).To(Equal(lines[42].Line))
+		Expect(offset).To(Equal(lines[42].Offset))
+
+		// back to the end
+		_, err = reader.Seek(0, io.SeekEnd)
+		Expect(err).To(BeNil())
+		line, _, err = reader.Next()
+		Expect(err).To(BeNil())
+		Expect(string(line)).To(Equal("Line 100 of the test file"))
+	})
+
+	It("rejects seeking into the middle of a line", func() {
+		reader := open(writeTestLines(10))
+
+		_, err := reader.Seek(-5, io.SeekEnd)
+		Expect(err).To(BeNil())
+		_, _, err = reader.Next()
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+
+		_, err = reader.Seek(1, io.SeekEnd)
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+	})
+
+	It("can't read on from the middle of a line longer than MaxLineLength", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 100
+
+		reader := open(writeTestFile("short\n" + strings.Repeat("a", 250) + "\nend\n"))
+
+		_, err := reader.Seek(200, io.SeekStart)
+		Expect(err).To(BeNil())
+		_, _, err = reader.Next()
+		Expect(err).To(MatchError(file.ErrLineTooLong))
+	})
+
+	It("strips CRLF line breaks and keeps a last line without a line break", func() {
+		reader := open(writeTestFile("first\r\nsecond\r\n\r\nlast"))
+
+		Expect(readAll(reader)).To(Equal([]file.LineReturn{
+			{Line: "last", Offset: 4},
+			{Line: "", Offset: 6},
+			{Line: "second", Offset: 14},
+			{Line: "first", Offset: 21},
+		}))
+	})
+
+	It("grows the buffer for long lines and truncates the ones over MaxLineLength", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 300
+
+		long := strings.Repeat("a", 250)
+		tooLong := strings.Repeat("b", 400)
+		reader := open(writeTestFile("short\n" + tooLong + "\n" + long + "\nend\n"))
+		reader.BufferSize = 16
+
+		lines := readAll(reader)
+		Expect(lines).To(HaveLen(4))
+		Expect(lines[0].Line).To(Equal("end"))
+		Expect(lines[1].Line).To(Equal(long))
+		Expect(lines[2].Line).To(Equal(strings.Repeat("b", 300) + "...[truncated 100 bytes]"))
+		Expect(lines[2].Offset).To(Equal(int64(4 + 251 + 401)))
+		Expect(lines[3]).To(Equal(file.LineReturn{Line: "short", Offset: reader.Size()}))
+	})
+
+	It("reads nothing from an empty file", func() {
+		reader := open(writeTestFile(""))
+
+		_, _, err := reader.Next()
+		Expect(err).To(Equal(io.EOF))
+	})
+})
+
+var _ = Describe("ReadLastNLinesMatchingP with CRLF line breaks", func() {
+	It("matches and returns lines without the line break", func() {
+		fileName := writeTestFile("one\r\ntwo\r\nthree")
+
+		// anchored at the end, the \r would keep it from matching
+		lines, err := file.ReadLastNLinesMatchingP(fileName, 10, file.RegexMatcher(regexp.MustCompile(`^(one|two|three)$`)))
+		Expect(err).To(BeNil())
+		trimmed := []string{}
+		for _, line := range lines {
+			trimmed = append(trimmed, string(file.TrimLineBreak(line)))
+		}
+		Expect(trimmed).To(Equal([]string{"three", "two", "one"}))
+	})
+})
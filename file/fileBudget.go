@@ -0,0 +1,45 @@
+package file
+
+// MaxResultBytes caps the bytes of the lines a single read collects, on top of the number of lines asked for.
+// a read stops once the next line would go over it and returns fewer lines, the newest ones.
+// the first line always fits, so paging through lines longer than the cap still moves on.
+// 0 means no cap. set it before serving any requests, like ReadBufferSize
+var MaxResultBytes = 0
+
+// resultBudget counts the bytes of the lines a read holds against MaxResultBytes
+type resultBudget struct {
+	limit int
+	used  int
+	lines int
+}
+
+func newResultBudget() *resultBudget {
+	return &resultBudget{limit: MaxResultBytes}
+}
+
+// take reports whether a line of length bytes still fits and counts it if it does
+func (budget *resultBudget) take(length int) bool {
+	if budget.limit > 0 && budget.lines > 0 && budget.used+length > budget.limit {
+		return false
+	}
+
+	budget.add(length)
+	return true
+}
+
+// add counts a line whether it fits or not, for readers that make room by dropping older lines (see exceeded)
+func (budget *resultBudget) add(length int) {
+	budget.used += length
+	budget.lines++
+}
+
+// release gives back the bytes of a line that has been dropped
+func (budget *resultBudget) release(length int) {
+	budget.used -= length
+	budget.lines--
+}
+
+// exceeded is true when the lines counted so far don't fit, unless it's only one
+func (budget *resultBudget) exceeded() bool {
+	return budget.limit > 0 && budget.lines > 1 && budget.used > budget.limit
+}
@@ -0,0 +1,73 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("MaxResultBytes", func() {
+	var fileName string
+
+	BeforeEach(func() {
+		// the lines at the end are 24 bytes long, 4 of them fit
+		file.MaxResultBytes = 100
+		DeferCleanup(func() { file.MaxResultBytes = 0 })
+		fileName = writeTestLines(99)
+	})
+
+	It("stops reading once the lines don't fit anymore", func() {
+		lines, err := file.ReadLastNLinesMatching(fileName, 10, file.MatchAll)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{
+			"Line 99 of the test file", "Line 98 of the test file", "Line 97 of the test file", "Line 96 of the test file",
+		}))
+
+		plines, err := file.ReadLastNLinesMatchingP(fileName, 10, file.MatchAll)
+		Expect(err).To(BeNil())
+		Expect(plines).To(HaveLen(4))
+
+		groups, err := file.ReadLastNMatchesWithContext(fileName, 5, file.KeywordMatcher("Line 9"), 1, 1)
+		Expect(err).To(BeNil())
+		Expect(groups).To(HaveLen(1))
+		Expect(groups[0].Lines).To(HaveLen(4))
+	})
+
+	It("carries on with the next page where the budget ran out", func() {
+		lines, cursor, err := file.ReadPage(fileName, 10, file.MatchAll, nil)
+		Expect(err).To(BeNil())
+		Expect(lines).To(HaveLen(4))
+		Expect(cursor).NotTo(BeNil())
+
+		lines, _, err = file.ReadPage(fileName, 10, file.MatchAll, cursor)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{
+			"Line 95 of the test file", "Line 94 of the test file", "Line 93 of the test file", "Line 92 of the test file",
+		}))
+	})
+
+	It("keeps the newest lines of compressed files", func() {
+		content := []string{}
+		for _, line := range []string{"96", "97", "98", "99"} {
+			content = append(content, "Line "+line+" of the test file")
+		}
+		compressed := filepath.Join(filepath.Dir(fileName), "test.log.gz")
+		writeGzipFile(compressed, "Line 95 of the test file\n"+strings.Join(content, "\n")+"\n")
+
+		lines, err := file.ReadLastNLinesMatching(compressed, 10, file.MatchAll)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{
+			"Line 99 of the test file", "Line 98 of the test file", "Line 97 of the test file", "Line 96 of the test file",
+		}))
+	})
+
+	It("always returns at least one line", func() {
+		file.MaxResultBytes = 10
+
+		lines, err := file.ReadLastNLinesMatching(fileName, 10, file.MatchAll)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"Line 99 of the test file"}))
+	})
+})
@@ -0,0 +1,174 @@
+package file
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Cluster is a group of lines with the same shape
+// Template is the line with its variable parts masked (<NUM>, <IP>...) or replaced by <*> where the lines differ.
+// Sample is the newest line of the cluster, FirstOffset the offset of the oldest and LastOffset of the newest one
+// (offsets are the same as the ones in LineReturn)
+type Cluster struct {
+	Template    string `json:"template"`
+	Count       int    `json:"count"`
+	Sample      string `json:"sample"`
+	FirstOffset int64  `json:"first_offset"`
+	LastOffset  int64  `json:"last_offset"`
+
+	tokens []string
+}
+
+// CLUSTER_SIMILARITY is the share of tokens a line needs to have in common with a cluster to join it
+const CLUSTER_SIMILARITY = 0.5
+
+// CLUSTER_WILDCARD replaces the tokens that differ between the lines of a cluster
+const CLUSTER_WILDCARD = "<*>"
+
+// clusterMasks replace the variable parts of a line, in order
+var clusterMasks = []struct {
+	pattern *regexp.Regexp
+	mask    string
+}{
+	{regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`), "<STR>"},
+	{regexp.MustCompile(`\d{4}-\d\d-\d\d[T ]\d\d:\d\d:\d\d(?:[.,]\d+)?(?:Z|[+-]\d\d:?\d\d)?`), "<TS>"},
+	{regexp.MustCompile(`\b\d\d:\d\d:\d\d(?:[.,]\d+)?\b`), "<TIME>"},
+	{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<UUID>"},
+	{regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d{1,5})?\b`), "<IP>"},
+	{regexp.MustCompile(`(?i)\b(?:[0-9a-f]{1,4}:){3,7}[0-9a-f]{1,4}\b`), "<IP>"},
+	{regexp.MustCompile(`(?i)\b0x[0-9a-f]+\b`), "<HEX>"},
+}
+
+var (
+	// hex strings (hashes, ids) need a letter and a digit, so that words and numbers aren't taken for them
+	hexPattern    = regexp.MustCompile(`\b[0-9a-fA-F]{8,}\b`)
+	numberPattern = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+)
+
+// MaskLine replaces the variable parts of line: quoted strings, timestamps, uuids, ip addresses, hex strings and numbers
+func MaskLine(line string) string {
+	for _, mask := range clusterMasks {
+		line = mask.pattern.ReplaceAllLiteralString(line, mask.mask)
+	}
+
+	line = hexPattern.ReplaceAllStringFunc(line, func(candidate string) string {
+		if strings.IndexFunc(candidate, isHexLetter) == -1 || strings.IndexAny(candidate, "0123456789") == -1 {
+			return candidate
+		}
+		return "<HEX>"
+	})
+
+	return numberPattern.ReplaceAllLiteralString(line, "<NUM>")
+}
+
+func isHexLetter(char rune) bool {
+	return (char >= 'a' && char <= 'f') || (char >= 'A' && char <= 'F')
+}
+
+// ClusterLastNLines groups the last n lines that matcher matches by their shape and returns the k biggest clusters,
+// largest first. the lines are masked (see MaskLine) and then clustered like Drain does:
+// lines with the same number of tokens and the same first token join the cluster they share
+// most tokens with (at least CLUSTER_SIMILARITY of them), the tokens that differ become <*>
+func ClusterLastNLines(fileName string, n int, k int, matcher Matcher) ([]Cluster, error) {
+	clusters := []*Cluster{}
+	groups := map[string][]*Cluster{}
+
+	readChunk, release, err := lineChunkReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	offset := int64(0)
+	for seen := 0; seen < n; {
+		chunk, err := readChunk(offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		offset = chunk[len(chunk)-1].Offset
+
+		for _, line := range chunk {
+			if !isMatchAll(matcher) && !matcher.Match(line.Line) {
+				continue
+			}
+
+			tokens := strings.Fields(MaskLine(line.Line))
+			key := clusterKey(tokens)
+			if cluster := bestCluster(groups[key], tokens); cluster != nil {
+				cluster.add(tokens, line)
+			} else {
+				cluster := &Cluster{Sample: line.Line, LastOffset: line.Offset, tokens: tokens}
+				cluster.add(tokens, line)
+				groups[key] = append(groups[key], cluster)
+				clusters = append(clusters, cluster)
+			}
+
+			if seen++; seen >= n {
+				break
+			}
+		}
+	}
+
+	// the most lines first, the most recent first among clusters of the same size
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if clusters[i].Count != clusters[j].Count {
+			return clusters[i].Count > clusters[j].Count
+		}
+		return clusters[i].LastOffset < clusters[j].LastOffset
+	})
+	if k >= 0 && len(clusters) > k {
+		clusters = clusters[:k]
+	}
+
+	top := make([]Cluster, len(clusters))
+	for i, cluster := range clusters {
+		top[i] = *cluster
+		top[i].Template = strings.Join(cluster.tokens, " ")
+	}
+	return top, nil
+}
+
+func clusterKey(tokens []string) string {
+	if len(tokens) == 0 {
+		return "0"
+	}
+	return strconv.Itoa(len(tokens)) + " " + tokens[0]
+}
+
+// bestCluster returns the cluster of group tokens has the most in common with, nil if none is similar enough
+func bestCluster(group []*Cluster, tokens []string) *Cluster {
+	var best *Cluster
+	bestSimilarity := 0.0
+	for _, cluster := range group {
+		same := 0
+		for i, token := range cluster.tokens {
+			if token == tokens[i] {
+				same++
+			}
+		}
+
+		similarity := 1.0
+		if len(tokens) > 0 {
+			similarity = float64(same) / float64(len(tokens))
+		}
+		if similarity >= CLUSTER_SIMILARITY && similarity > bestSimilarity {
+			best, bestSimilarity = cluster, similarity
+		}
+	}
+	return best
+}
+
+// add counts line in the cluster, the lines come newest first
+func (cluster *Cluster) add(tokens []string, line LineReturn) {
+	for i, token := range tokens {
+		if cluster.tokens[i] != token {
+			cluster.tokens[i] = CLUSTER_WILDCARD
+		}
+	}
+	cluster.Count++
+	cluster.FirstOffset = line.Offset
+}
@@ -0,0 +1,70 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"strings"
+)
+
+var _ = Describe("MaskLine", func() {
+	It("masks the variable parts", func() {
+		for line, expected := range map[string]string{
+			`2026-10-17T14:02:03.123Z took 15.5 ms`:               `<TS> took <NUM> ms`,
+			`Oct 17 14:02:03 host sshd[4242]: from 10.0.0.1:5522`: `Oct <NUM> <TIME> host sshd[<NUM>]: from <IP>`,
+			`request 3f2b8c1e-9a7d-4c1b-8e2f-0123456789ab done`:   `request <UUID> done`,
+			`commit deadbeef42 at 0x7ffe`:                         `commit <HEX> at <HEX>`,
+			`user "alice smith" said 'hi'`:                        `user <STR> said <STR>`,
+			`v2 user123 fe80:0:0:0:202:b3ff:fe1e:8329 decade`:     `v2 user123 <IP> decade`,
+		} {
+			Expect(file.MaskLine(line)).To(Equal(expected), line)
+		}
+	})
+})
+
+var _ = Describe("ClusterLastNLines", func() {
+	var fileName string
+
+	BeforeEach(func() {
+		lines := []string{}
+		for i := 0; i < 100; i++ {
+			lines = append(lines, fmt.Sprintf("2026-10-17T14:%02d:00Z GET /api/users/%d 200 in %dms", i%60, i, i*3))
+			if i%4 == 0 {
+				lines = append(lines, fmt.Sprintf("2026-10-17T14:%02d:01Z connection from 10.0.%d.1 reset by %s", i%60, i, []string{"alice", "bob"}[i%8/4]))
+			}
+			if i%25 == 0 {
+				lines = append(lines, "2026-10-17T14:00:02Z cache warmed up")
+			}
+		}
+		fileName = writeTestFile(strings.Join(lines, "\n") + "\n")
+	})
+
+	It("returns the biggest clusters with their templates", func() {
+		clusters, err := file.ClusterLastNLines(fileName, 10000, 10, file.MatchAll)
+		Expect(err).To(BeNil())
+		Expect(clusters).To(HaveLen(3))
+
+		Expect(clusters[0].Template).To(Equal("<TS> GET /api/users/<NUM> <NUM> in <*>"))
+		Expect(clusters[0].Count).To(Equal(100))
+		Expect(clusters[0].Sample).To(Equal("2026-10-17T14:39:00Z GET /api/users/99 200 in 297ms"))
+
+		Expect(clusters[1].Template).To(Equal("<TS> connection from <IP> reset by <*>"))
+		Expect(clusters[1].Count).To(Equal(25))
+
+		Expect(clusters[2].Template).To(Equal("<TS> cache warmed up"))
+		Expect(clusters[2].Count).To(Equal(4))
+		// the oldest is the third line of the file
+		Expect(clusters[2].FirstOffset).To(BeNumerically(">", clusters[2].LastOffset))
+	})
+
+	It("limits the lines and the clusters", func() {
+		clusters, err := file.ClusterLastNLines(fileName, 10, 1, file.KeywordMatcher("GET"))
+		Expect(err).To(BeNil())
+		Expect(clusters).To(HaveLen(1))
+		Expect(clusters[0].Count).To(Equal(10))
+
+		lines, _, _ := file.ReadLastNLinesWithKeywordPagination(fileName, 10, "GET", 0)
+		Expect(clusters[0].Sample).To(Equal(lines[0]))
+	})
+})
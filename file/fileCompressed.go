@@ -0,0 +1,395 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// compressed files can't be read backwards, instead they are decompressed from the start
+// and the last n lines are kept in a ring. offsets are counted from the end of the decompressed content
+
+// decompressedSizes caches the decompressed size of compressed files
+// rotated compressed files don't change, so the identity (which includes the size) is a good enough key
+var decompressedSizes = struct {
+	sync.Mutex
+	sizes map[FileIdentity]int64
+}{sizes: map[FileIdentity]int64{}}
+
+type Compression string
+
+const (
+	CompressionNone  Compression = "none"
+	CompressionGzip  Compression = "gzip"
+	CompressionZstd  Compression = "zstd"
+	CompressionBzip2 Compression = "bzip2"
+)
+
+// magic bytes at the start of a compressed file
+var compressionMagics = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{CompressionBzip2, []byte("BZh")},
+}
+
+// DetectCompression tells from the first bytes of fileName whether and how it is compressed
+// the file name (.gz, .zst...) isn't trusted, rotated logs are named all kinds of ways
+func DetectCompression(fileName string) (Compression, error) {
+	file, _, err := openForRead(fileName)
+	if err != nil {
+		return CompressionNone, err
+	}
+	defer file.Close()
+
+	return detectCompression(file)
+}
+
+func detectCompression(file *os.File) (Compression, error) {
+	header := make([]byte, 4)
+	n, err := file.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return CompressionNone, err
+	}
+
+	for _, m := range compressionMagics {
+		if bytes.HasPrefix(header[:n], m.magic) {
+			return m.compression, nil
+		}
+	}
+
+	return CompressionNone, nil
+}
+
+// isCompressed is DetectCompression for callers that fall back to reading the file as plain text on errors
+// (which they'll then run into again and report)
+func isCompressed(fileName string) bool {
+	compression, _ := DetectCompression(fileName)
+	return compression != CompressionNone
+}
+
+// openDecompressed opens fileName and returns a reader of its decompressed content
+func openDecompressed(fileName string) (io.ReadCloser, FileIdentity, error) {
+	file, _, err := openForRead(fileName)
+	if err != nil {
+		return nil, FileIdentity{}, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, FileIdentity{}, err
+	}
+
+	compression, err := detectCompression(file)
+	if err != nil {
+		file.Close()
+		return nil, FileIdentity{}, err
+	}
+
+	reader, release, err := newDecompressor(file, compression)
+	if err != nil {
+		file.Close()
+		return nil, FileIdentity{}, err
+	}
+
+	return &decompressedFile{Reader: reader, file: file, release: release}, identityFromFileInfo(stat), nil
+}
+
+// newDecompressor wraps reader into a reader of its decompressed content
+// release (if not nil) needs to be called once the decompressor is no longer used
+func newDecompressor(reader io.Reader, compression Compression) (io.Reader, func(), error) {
+	switch compression {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(reader)
+		return gzipReader, nil, err
+	case CompressionZstd:
+		zstdReader, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zstdReader, zstdReader.Close, nil
+	case CompressionBzip2:
+		return bzip2.NewReader(reader), nil, nil
+	}
+
+	return reader, nil, nil
+}
+
+type decompressedFile struct {
+	io.Reader
+	file    *os.File
+	release func()
+}
+
+func (d *decompressedFile) Close() error {
+	if d.release != nil {
+		d.release()
+	}
+	return d.file.Close()
+}
+
+// DEFAULT_MAX_DECOMPRESSED_SIZE is what SetMaxDecompressedSize starts out with
+const DEFAULT_MAX_DECOMPRESSED_SIZE = 1 << 30
+
+// maxDecompressedSize is the most bytes a compressed file is decompressed into a spill (see spillDecompressed),
+// and the most bytes the spills kept around for later requests take up together. atomic, the config reload changes it
+var maxDecompressedSize atomic.Int64
+
+func init() {
+	maxDecompressedSize.Store(DEFAULT_MAX_DECOMPRESSED_SIZE)
+}
+
+// SetMaxDecompressedSize sets the most bytes a compressed file that is walked backwards may decompress to,
+// bigger ones are rejected with ErrDecompressedTooLarge
+func SetMaxDecompressedSize(size int64) {
+	maxDecompressedSize.Store(size)
+}
+
+// spill is the decompressed content of a compressed file in a temporary file, shared by the requests reading it
+type spill struct {
+	// ready is closed once reader (or err) is set
+	ready  chan struct{}
+	reader Reader
+	err    error
+	// users is how many requests are reading it, lastUsed when the last one was done
+	users    int
+	lastUsed time.Time
+}
+
+// spillKey tells compressed files apart, rotated compressed files don't change.
+// the modification time keeps a new file that got the inode of a deleted one from picking up its spill
+type spillKey struct {
+	identity FileIdentity
+	modTime  int64
+}
+
+// spills caches the spills by compressed file
+var spills = struct {
+	sync.Mutex
+	byKey map[spillKey]*spill
+}{byKey: map[spillKey]*spill{}}
+
+// spillDecompressed returns the decompressed content of fileName for the callers that walk all of it backwards.
+// it's decompressed into a temporary file the first time and kept for the next requests,
+// offsets in it are counted from the end of the decompressed content like the ones of collectLastLinesCompressed.
+// release needs to be called once the reader is no longer used, it must not be closed
+func spillDecompressed(fileName string) (Reader, func(), error) {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return nil, nil, translateOpenError(fileName, err)
+	}
+	key := spillKey{identity: identityFromFileInfo(stat), modTime: stat.ModTime().UnixNano()}
+
+	spills.Lock()
+	entry, found := spills.byKey[key]
+	if !found {
+		entry = &spill{ready: make(chan struct{})}
+		spills.byKey[key] = entry
+	}
+	entry.users++
+	spills.Unlock()
+
+	release := func() {
+		spills.Lock()
+		defer spills.Unlock()
+		entry.users--
+		entry.lastUsed = time.Now()
+		evictSpills()
+	}
+
+	if !found {
+		reader, err := writeSpill(fileName)
+		spills.Lock()
+		entry.reader, entry.err = reader, err
+		if err != nil {
+			// the next request tries again
+			delete(spills.byKey, key)
+		}
+		spills.Unlock()
+		close(entry.ready)
+	}
+	<-entry.ready
+
+	if entry.err != nil {
+		release()
+		return nil, nil, entry.err
+	}
+	return entry.reader, release, nil
+}
+
+// writeSpill decompresses fileName into a temporary file, ErrDecompressedTooLarge once it gets bigger than maxDecompressedSize.
+// the temporary file is removed right away, it's gone once the returned Reader is closed
+func writeSpill(fileName string) (Reader, error) {
+	decompressed, _, err := openDecompressed(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressed.Close()
+
+	file, err := os.CreateTemp("", "logmonitor-*.decompressed")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	limit := maxDecompressedSize.Load()
+	written, err := io.Copy(file, io.LimitReader(decompressed, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	if written > limit {
+		return nil, fmt.Errorf("%w: %s decompresses to more than %d bytes", ErrDecompressedTooLarge, fileName, limit)
+	}
+	return OpenReader(file.Name(), DefaultReaderBackend)
+}
+
+// evictSpills closes the least recently used spills nobody is reading
+// until the ones left take up no more than maxDecompressedSize. spills needs to be locked
+func evictSpills() {
+	for {
+		total := int64(0)
+		var oldest *spill
+		var oldestKey spillKey
+		for key, entry := range spills.byKey {
+			if entry.reader == nil {
+				continue
+			}
+			total += entry.reader.Size()
+			if entry.users == 0 && (oldest == nil || entry.lastUsed.Before(oldest.lastUsed)) {
+				oldest, oldestKey = entry, key
+			}
+		}
+		if total <= maxDecompressedSize.Load() || oldest == nil {
+			return
+		}
+
+		oldest.reader.Close()
+		delete(spills.byKey, oldestKey)
+	}
+}
+
+// decompressedSize returns the size of the decompressed content of fileName
+func decompressedSize(fileName string) (int64, error) {
+	reader, identity, err := openDecompressed(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	decompressedSizes.Lock()
+	size, found := decompressedSizes.sizes[identity]
+	decompressedSizes.Unlock()
+	if found {
+		return size, nil
+	}
+
+	size, err = io.Copy(io.Discard, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	decompressedSizes.Lock()
+	decompressedSizes.sizes[identity] = size
+	decompressedSizes.Unlock()
+
+	return size, nil
+}
+
+// collectLastLinesCompressed is collectLastLines for compressed files
+// returns the lines, the offset the next read should start from and the decompressed size of the file
+func collectLastLinesCompressed(
+	fileName string, n int, matcher Matcher, offset int64, budget *resultBudget) ([]LineReturn, int64, int64, error) {
+	total, err := decompressedSize(fileName)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// the lines we're after end at limit
+	limit := total - offset
+	if offset < 0 || n <= 0 || limit <= 0 {
+		return []LineReturn{}, offset, total, nil
+	}
+
+	reader, _, err := openDecompressed(fileName)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer reader.Close()
+
+	// the n latest matching lines in front of limit, oldest first
+	// it grows as lines come in, n can be a lot bigger than the number of lines in the file
+	ring := []LineReturn{}
+	// older lines have been dropped to stay within budget
+	cutOff := false
+
+	bufReader := bufio.NewReaderSize(reader, ReadBufferSize)
+	var position int64 = 0
+	for position < limit {
+		// only the beginning of a long line is kept, size is what it takes up in the file
+		line, size, err := readForwardLine(bufReader)
+		if err != nil && err != io.EOF {
+			return nil, 0, 0, err
+		}
+
+		lineStart := position
+		position += size
+		if position > limit {
+			return nil, 0, 0, ErrOffsetNotAtLineBoundary
+		}
+
+		length := size
+		if err == nil {
+			// the line break
+			length--
+		}
+		content := truncateLinePrefix(line, length)
+		if size > 0 && (isMatchAll(matcher) || matcher.Match(content)) {
+			ring = append(ring, LineReturn{Line: content, Offset: total - lineStart})
+			budget.add(len(content))
+			if len(ring) > n {
+				budget.release(len(ring[0].Line))
+				ring = ring[1:]
+			}
+			for len(ring) > 0 && budget.exceeded() {
+				budget.release(len(ring[0].Line))
+				ring = ring[1:]
+				cutOff = true
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	// newest first like the rest of the readers
+	lines := make([]LineReturn, len(ring))
+	for i := range ring {
+		lines[i] = ring[len(ring)-1-i]
+	}
+
+	if cutOff {
+		// the next read picks up the lines that didn't fit
+		if len(lines) == 0 {
+			return lines, offset, total, nil
+		}
+		return lines, lines[len(lines)-1].Offset, total, nil
+	}
+	if len(lines) < n {
+		return lines, total, total, nil
+	}
+
+	return lines, lines[n-1].Offset, total, nil
+}
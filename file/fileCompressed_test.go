@@ -0,0 +1,165 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// "bz 1\nbz 2\nbz 3\n", the standard library can only decompress bzip2
+var bzip2Content = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xed, 0xe7, 0xf9, 0xdb, 0x00, 0x00,
+	0x04, 0xd9, 0x80, 0x00, 0x10, 0x40, 0x00, 0x38, 0x00, 0x10, 0x00, 0x00, 0x10, 0x20, 0x00, 0x31,
+	0x0c, 0x01, 0x1e, 0x91, 0x88, 0xf9, 0xd2, 0x10, 0xa7, 0x8b, 0xb9, 0x22, 0x9c, 0x28, 0x48, 0x76,
+	0xf3, 0xfc, 0xed, 0x80,
+}
+
+func writeZstdFile(fileName string, content string) {
+	f, err := os.Create(fileName)
+	Expect(err).To(BeNil())
+	defer f.Close()
+
+	writer, err := zstd.NewWriter(f)
+	Expect(err).To(BeNil())
+	writer.Write([]byte(content))
+	Expect(writer.Close()).To(Succeed())
+}
+
+var _ = Describe("compressed files", func() {
+	var content string
+	var plainFile string
+
+	BeforeEach(func() {
+		lines := []string{}
+		for i := 1; i <= 2000; i++ {
+			animal := "dog"
+			if i%3 == 0 {
+				animal = "cat"
+			}
+			lines = append(lines, "Line "+strings.Repeat("x", i%50)+" "+animal)
+		}
+		content = strings.Join(lines, "\n") + "\n"
+		plainFile = writeTestFile(content)
+	})
+
+	It("detects the compression from the magic bytes", func() {
+		writeGzipFile(plainFile+".gz", content)
+		writeZstdFile(plainFile+".zst", content)
+		Expect(os.WriteFile(plainFile+".bz2", bzip2Content, 0644)).To(Succeed())
+
+		for fileName, expected := range map[string]file.Compression{
+			plainFile:          file.CompressionNone,
+			plainFile + ".gz":  file.CompressionGzip,
+			plainFile + ".zst": file.CompressionZstd,
+			plainFile + ".bz2": file.CompressionBzip2,
+		} {
+			compression, err := file.DetectCompression(fileName)
+			Expect(err).To(BeNil())
+			Expect(compression).To(Equal(expected))
+		}
+	})
+
+	It("reads gzip and zstd files the same way as plain files", func() {
+		// the names don't give the compression away
+		writeGzipFile(plainFile+".a", content)
+		writeZstdFile(plainFile+".b", content)
+
+		for _, fileName := range []string{plainFile + ".a", plainFile + ".b"} {
+			for _, query := range []string{"", "cat"} {
+				expected, _ := file.ReadLastNLinesWithKeyword(plainFile, 100, query)
+				lines, err := file.ReadLastNLinesWithKeyword(fileName, 100, query)
+				Expect(err).To(BeNil())
+				Expect(lines).To(Equal(expected))
+
+				expectedP, _ := file.ReadLastNLinesWithKeywordP(plainFile, 100, query)
+				linesP, err := file.ReadLastNLinesWithKeywordP(fileName, 100, query)
+				Expect(err).To(BeNil())
+				Expect(linesP).To(Equal(expectedP))
+
+				var expectedOffset, offset int64 = 0, 0
+				for i := 0; i < 5; i++ {
+					expected, expectedOffset, _ = file.ReadLastNLinesWithKeywordPagination(plainFile, 500, query, expectedOffset)
+					lines, offset, err = file.ReadLastNLinesWithKeywordPagination(fileName, 500, query, offset)
+					Expect(err).To(BeNil())
+					Expect(lines).To(Equal(expected))
+					Expect(offset).To(Equal(expectedOffset))
+				}
+			}
+		}
+	})
+
+	It("walks compressed files backwards like plain files", func() {
+		fileName := plainFile + ".gz"
+		writeGzipFile(fileName, content)
+
+		expected, err := file.ReadLastNMatchesWithContext(plainFile, 5, file.KeywordMatcher("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx "), 2, 1)
+		Expect(err).To(BeNil())
+		groups, err := file.ReadLastNMatchesWithContext(fileName, 5, file.KeywordMatcher("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx "), 2, 1)
+		Expect(err).To(BeNil())
+		Expect(groups).To(Equal(expected))
+
+		skip, err := file.SkipLinesOffset(plainFile, 1500)
+		Expect(err).To(BeNil())
+		Expect(file.SkipLinesOffset(fileName, 1500)).To(Equal(skip))
+
+		// the spilled copy is gone
+		spilled, err := filepath.Glob(filepath.Join(os.TempDir(), "logmonitor-*.decompressed"))
+		Expect(err).To(BeNil())
+		Expect(spilled).To(BeEmpty())
+	})
+
+	It("rejects walking compressed files that decompress to more than the limit", func() {
+		defer file.SetMaxDecompressedSize(file.DEFAULT_MAX_DECOMPRESSED_SIZE)
+		file.SetMaxDecompressedSize(int64(len(content)) - 1)
+
+		fileName := plainFile + ".gz"
+		writeGzipFile(fileName, content)
+
+		_, err := file.ReadLastNMatchesWithContext(fileName, 5, file.KeywordMatcher("cat"), 2, 1)
+		Expect(err).To(MatchError(file.ErrDecompressedTooLarge))
+
+		// reading the last lines doesn't need the whole file
+		lines, err := file.ReadLastNLinesWithKeyword(fileName, 2, "")
+		Expect(err).To(BeNil())
+		Expect(lines).To(HaveLen(2))
+
+		file.SetMaxDecompressedSize(int64(len(content)))
+		_, err = file.ReadLastNMatchesWithContext(fileName, 5, file.KeywordMatcher("cat"), 2, 1)
+		Expect(err).To(BeNil())
+	})
+
+	It("truncates lines longer than MaxLineLength like plain files", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 100
+
+		content := "first\n" + strings.Repeat("x", 50000) + "\nlast\n"
+		plainFile := writeTestFile(content)
+		writeGzipFile(plainFile+".gz", content)
+
+		lines, err := file.ReadLastNLinesWithKeyword(plainFile+".gz", 10, "")
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"last", strings.Repeat("x", 100) + "...[truncated 49900 bytes]", "first"}))
+		Expect(file.ReadLastNLinesWithKeyword(plainFile, 10, "")).To(Equal(lines))
+	})
+
+	It("reads bzip2 files", func() {
+		fileName := plainFile + ".bz2"
+		Expect(os.WriteFile(fileName, bzip2Content, 0644)).To(Succeed())
+
+		lines, err := file.ReadLastNLinesWithKeyword(fileName, 2, "")
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"bz 3", "bz 2"}))
+	})
+
+	It("rejects offsets in the middle of a line", func() {
+		fileName := plainFile + ".gz"
+		writeGzipFile(fileName, content)
+
+		_, _, err := file.ReadLastNLinesWithKeywordPagination(fileName, 10, "", 3)
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+	})
+})
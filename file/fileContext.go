@@ -0,0 +1,141 @@
+package file
+
+// ContextLine is a line returned by ReadLastNMatchesWithContext
+// Match tells the matching lines apart from the context around them
+type ContextLine struct {
+	Line   string `json:"line"`
+	Offset int64  `json:"offset"`
+	Match  bool   `json:"match"`
+}
+
+// MatchGroup is a run of adjacent lines, newest first, holding one or more matches and the context around them
+type MatchGroup struct {
+	Lines []ContextLine `json:"lines"`
+}
+
+// ReadLastNMatchesWithContext works like grep -B before -A after on the last n lines that matcher matches.
+// before are the older lines in front of a match, after the newer lines behind it.
+// matches whose context overlaps or touches end up in the same group, like grep does.
+// the offsets are the same as the ones in LineReturn
+func ReadLastNMatchesWithContext(fileName string, n int, matcher Matcher, before int, after int) ([]MatchGroup, error) {
+	groups := []MatchGroup{}
+	if n <= 0 {
+		return groups, nil
+	}
+
+	readChunk, release, err := lineChunkReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// the newest lines that aren't part of a group yet, they become the after context of the next match
+	recent := []ContextLine{}
+	// the group we're still collecting before context for, and how many more lines it needs
+	var group *MatchGroup
+	remainingBefore := 0
+	// index of the last line in groups, counting lines from the end of the file
+	lastGroupedIndex := -2
+	lineIndex := -1
+	matches := 0
+
+	closeGroup := func() {
+		groups = append(groups, *group)
+		lastGroupedIndex = lineIndex
+		group = nil
+	}
+
+	budget := newResultBudget()
+	// grow adds lines to the group, false once they don't fit into the budget anymore
+	grow := func(lines ...ContextLine) bool {
+		for _, line := range lines {
+			if !budget.take(len(line.Line)) {
+				return false
+			}
+			group.Lines = append(group.Lines, line)
+		}
+		return true
+	}
+
+	var offset int64 = 0
+	for {
+		lines, err := readChunk(offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(lines) == 0 {
+			break
+		}
+		offset = lines[len(lines)-1].Offset
+
+		for _, line := range lines {
+			lineIndex++
+			contextLine := ContextLine{Line: line.Line, Offset: line.Offset}
+			// once we have n matches, we only keep reading to complete the before context of the last one
+			contextLine.Match = (matches < n || group != nil) && matcher.Match(line.Line)
+
+			switch {
+			case contextLine.Match && matches < n:
+				matches++
+				if group == nil {
+					firstIndex := lineIndex - len(recent)
+					if firstIndex == lastGroupedIndex+1 && len(groups) > 0 {
+						// this group picks up right where the last one ended, merge them
+						previous := groups[len(groups)-1]
+						groups = groups[:len(groups)-1]
+						group = &previous
+					} else {
+						group = &MatchGroup{Lines: []ContextLine{}}
+					}
+					fits := grow(recent...)
+					recent = recent[:0]
+					if !fits {
+						return closeFull(groups, group), nil
+					}
+				}
+				if !grow(contextLine) {
+					return closeFull(groups, group), nil
+				}
+				remainingBefore = before
+
+			case group != nil:
+				if !grow(contextLine) {
+					return closeFull(groups, group), nil
+				}
+				remainingBefore--
+
+			case after > 0:
+				if len(recent) == after {
+					recent = append(recent[:0], recent[1:]...)
+				}
+				recent = append(recent, contextLine)
+			}
+
+			if group != nil && remainingBefore == 0 {
+				closeGroup()
+			}
+		}
+
+		if matches == n && group == nil {
+			break
+		}
+	}
+
+	// the beginning of the file cut the before context short
+	if group != nil {
+		closeGroup()
+	}
+
+	return groups, nil
+}
+
+// closeFull ends the read when the budget is used up, with the lines of the last group that did fit
+// unless none of them is a match
+func closeFull(groups []MatchGroup, group *MatchGroup) []MatchGroup {
+	for _, line := range group.Lines {
+		if line.Match {
+			return append(groups, *group)
+		}
+	}
+	return groups
+}
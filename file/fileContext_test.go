@@ -0,0 +1,70 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReadLastNMatchesWithContext", func() {
+	// lines of a group, newest first, matches are marked with a *
+	groupLines := func(groups []file.MatchGroup) [][]string {
+		result := [][]string{}
+		for _, group := range groups {
+			lines := []string{}
+			for _, line := range group.Lines {
+				if line.Match {
+					lines = append(lines, line.Line+"*")
+				} else {
+					lines = append(lines, line.Line)
+				}
+			}
+			result = append(result, lines)
+		}
+		return result
+	}
+
+	var fileName string
+	BeforeEach(func() {
+		fileName = writeTestFile("1\n2\nERROR 3\n4\n5\n6\n7\nERROR 8\n9\nERROR 10\n11\n12\n")
+	})
+
+	It("returns the lines before and after each match", func() {
+		groups, err := file.ReadLastNMatchesWithContext(fileName, 1, file.KeywordMatcher("ERROR"), 1, 2)
+		Expect(err).To(BeNil())
+		Expect(groupLines(groups)).To(Equal([][]string{{"12", "11", "ERROR 10*", "9"}}))
+	})
+
+	It("merges overlapping context", func() {
+		groups, err := file.ReadLastNMatchesWithContext(fileName, 3, file.KeywordMatcher("ERROR"), 1, 1)
+		Expect(err).To(BeNil())
+		Expect(groupLines(groups)).To(Equal([][]string{
+			{"11", "ERROR 10*", "9", "ERROR 8*", "7"},
+			{"4", "ERROR 3*", "2"},
+		}))
+	})
+
+	It("merges touching context", func() {
+		groups, err := file.ReadLastNMatchesWithContext(fileName, 3, file.KeywordMatcher("ERROR"), 0, 1)
+		Expect(err).To(BeNil())
+		Expect(groupLines(groups)).To(Equal([][]string{
+			{"11", "ERROR 10*", "9", "ERROR 8*"},
+			{"4", "ERROR 3*"},
+		}))
+	})
+
+	It("stops at the beginning of the file", func() {
+		groups, err := file.ReadLastNMatchesWithContext(fileName, 10, file.KeywordMatcher("ERROR 3"), 5, 0)
+		Expect(err).To(BeNil())
+		Expect(groupLines(groups)).To(Equal([][]string{{"ERROR 3*", "2", "1"}}))
+		Expect(groups[0].Lines[2].Offset).To(Equal(int64(len("1\n2\nERROR 3\n4\n5\n6\n7\nERROR 8\n9\nERROR 10\n11\n12\n"))))
+	})
+
+	It("works on compressed files", func() {
+		writeGzipFile(fileName+".gz", "1\n2\nERROR 3\n4\n")
+
+		groups, err := file.ReadLastNMatchesWithContext(fileName+".gz", 1, file.KeywordMatcher("ERROR"), 1, 1)
+		Expect(err).To(BeNil())
+		Expect(groupLines(groups)).To(Equal([][]string{{"4", "ERROR 3*", "2"}}))
+	})
+})
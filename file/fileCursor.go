@@ -0,0 +1,85 @@
+package file
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidCursor     = errors.New("invalid cursor")
+	ErrCursorFileChanged = errors.New("file has been rotated or truncated since the cursor was issued")
+)
+
+// Cursor remembers where a paginated read stopped
+// Offset is counted from the end of the file (same as LineReturn.Offset) at the time the cursor was issued,
+// Device, Inode and Size identify the file at that time so the cursor can't be replayed against another file.
+// Forward is set for the cursors of ReadPageForward, they only work in that direction
+type Cursor struct {
+	Offset  int64  `json:"o"`
+	Device  uint64 `json:"d"`
+	Inode   uint64 `json:"i"`
+	Size    int64  `json:"s"`
+	Forward bool   `json:"f,omitempty"`
+}
+
+// Identity returns the identity of the file the cursor was issued for
+func (cursor Cursor) Identity() FileIdentity {
+	return FileIdentity{Device: cursor.Device, Inode: cursor.Inode, Size: cursor.Size}
+}
+
+// EncodeCursor serializes the cursor into an opaque url safe token signed with secret
+func EncodeCursor(cursor Cursor, secret []byte) string {
+	payload, _ := json.Marshal(cursor)
+
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	signature := base64.RawURLEncoding.EncodeToString(signCursor(encodedPayload, secret))
+
+	return encodedPayload + "." + signature
+}
+
+// DecodeCursor verifies the signature of token and returns the cursor it carries
+func DecodeCursor(token string, secret []byte) (Cursor, error) {
+	encodedPayload, encodedSignature, found := strings.Cut(token, ".")
+	if !found {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, signCursor(encodedPayload, secret)) {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	cursor := Cursor{}
+	if err := json.Unmarshal(payload, &cursor); err != nil || cursor.Offset < 0 || cursor.Size < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+// ResolveCursor checks that cursor still refers to the file currently at identity
+// and translates its offset to the current end of the file.
+// the offsets are counted from EOF, so if the file has grown since the cursor was issued,
+// the same line is now further away from the end
+func ResolveCursor(cursor Cursor, identity FileIdentity) (int64, error) {
+	if !cursor.Identity().SameFile(identity) || cursor.Size > identity.Size {
+		return 0, ErrCursorFileChanged
+	}
+
+	return cursor.Offset + identity.Size - cursor.Size, nil
+}
+
+func signCursor(encodedPayload string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
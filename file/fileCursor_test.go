@@ -0,0 +1,88 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+)
+
+var _ = Describe("Cursor", func() {
+	secret := []byte("secret")
+
+	It("round trips through EncodeCursor and DecodeCursor", func() {
+		cursor := file.Cursor{Offset: 4933, Inode: 42, Size: 4980}
+
+		decoded, err := file.DecodeCursor(file.EncodeCursor(cursor, secret), secret)
+		Expect(err).To(BeNil())
+		Expect(decoded).To(Equal(cursor))
+	})
+
+	It("rejects tampered cursors", func() {
+		token := file.EncodeCursor(file.Cursor{Offset: 10, Inode: 42, Size: 100}, secret)
+		forged := file.EncodeCursor(file.Cursor{Offset: 20, Inode: 42, Size: 100}, []byte("guess"))
+
+		_, err := file.DecodeCursor(token, []byte("another secret"))
+		Expect(err).To(Equal(file.ErrInvalidCursor))
+
+		_, err = file.DecodeCursor(forged, secret)
+		Expect(err).To(Equal(file.ErrInvalidCursor))
+
+		_, err = file.DecodeCursor("garbage", secret)
+		Expect(err).To(Equal(file.ErrInvalidCursor))
+	})
+
+	It("shifts the offset when the file has grown", func() {
+		cursor := file.Cursor{Offset: 10, Inode: 42, Size: 100}
+
+		offset, err := file.ResolveCursor(cursor, file.FileIdentity{Inode: 42, Size: 150})
+		Expect(err).To(BeNil())
+		Expect(offset).To(Equal(int64(60)))
+	})
+
+	It("rejects cursors of rotated or truncated files", func() {
+		cursor := file.Cursor{Offset: 10, Inode: 42, Size: 100}
+
+		_, err := file.ResolveCursor(cursor, file.FileIdentity{Inode: 43, Size: 150})
+		Expect(err).To(Equal(file.ErrCursorFileChanged))
+
+		_, err = file.ResolveCursor(cursor, file.FileIdentity{Inode: 42, Size: 50})
+		Expect(err).To(Equal(file.ErrCursorFileChanged))
+	})
+
+	It("resumes a paginated read after lines are appended", func() {
+		fileName := writeTestLines(10)
+		identity, _ := file.GetFileIdentity(fileName)
+
+		lines, offset, err := file.ReadLastNLinesWithKeywordPagination(fileName, 3, "", 0)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{
+			"Line 10 of the test file", "Line 9 of the test file", "Line 8 of the test file"}))
+		token := file.EncodeCursor(file.Cursor{
+			Offset: offset, Device: identity.Device, Inode: identity.Inode, Size: identity.Size}, secret)
+
+		f, _ := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+		f.WriteString("Line 11 of the test file\n")
+		f.Close()
+
+		cursor, _ := file.DecodeCursor(token, secret)
+		identity, _ = file.GetFileIdentity(fileName)
+		offset, err = file.ResolveCursor(cursor, identity)
+		Expect(err).To(BeNil())
+
+		lines, _, _ = file.ReadLastNLinesWithKeywordPagination(fileName, 2, "", offset)
+		Expect(lines).To(Equal([]string{"Line 7 of the test file", "Line 6 of the test file"}))
+	})
+})
+
+var _ = Describe("ReadLastNLinesWithKeywordPagination at the beginning of the file", func() {
+	It("returns the scanned offset when there are no matches", func() {
+		fileName := writeTestLines(10)
+		identity, _ := file.GetFileIdentity(fileName)
+
+		lines, offset, err := file.ReadLastNLinesWithKeywordPagination(fileName, 3, "no such line", 0)
+		Expect(err).To(BeNil())
+		Expect(lines).To(BeEmpty())
+		Expect(offset).To(Equal(identity.Size))
+	})
+})
@@ -0,0 +1,95 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	ErrFileNotFound            = errors.New("file not found")
+	ErrPermissionDenied        = errors.New("permission denied")
+	ErrOffsetNotAtLineBoundary = errors.New("offset is not at a line boundary")
+	ErrLineTooLong             = errors.New("log line is longer than the max line length")
+	ErrCompressedForward       = errors.New("compressed files can only be read newest first")
+	ErrDecompressedTooLarge    = errors.New("compressed file is too large to decompress")
+)
+
+// openForRead opens fileName and returns it along with its current size
+// os errors are translated into the errors above so that callers can tell them apart with errors.Is
+func openForRead(fileName string) (*os.File, int64, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, translateOpenError(fileName, err)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, translateOpenError(fileName, err)
+	}
+
+	return file, stat.Size(), nil
+}
+
+// readBufferAt fills buf with the bytes of file starting at bufStart
+// a short read means the file has been truncated under us
+func readBufferAt(file Reader, buf []byte, bufStart int64) error {
+	_, err := file.ReadAt(buf, bufStart)
+	if err == io.EOF {
+		return fmt.Errorf("%s: short read at %d: %w", file.Name(), bufStart, io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", file.Name(), err)
+	}
+
+	return nil
+}
+
+// boundaryError is the error for a position (from the start of the file) that isn't at the start of a line.
+// lines longer than MaxLineLength are returned truncated, so a position in the middle of one
+// can't be read from like a shorter line either: ErrLineTooLong for those, ErrOffsetNotAtLineBoundary otherwise
+func boundaryError(file Reader, fileSize int64, position int64) error {
+	start := position - int64(MaxLineLength)
+	if start < 0 {
+		start = 0
+	}
+	before, err := file.Slice(start, position)
+	if err != nil {
+		return err
+	}
+
+	index := bytes.LastIndexByte(before, '\n')
+	if index == -1 && start > 0 {
+		return fmt.Errorf("%w: offset %d is inside a line longer than %d bytes", ErrLineTooLong, fileSize-position, MaxLineLength)
+	}
+
+	// the line is too long if there's no line break within MaxLineLength bytes of its start
+	lineStart := start + int64(index) + 1
+	end := lineStart + int64(MaxLineLength) + 1
+	if end >= fileSize {
+		return ErrOffsetNotAtLineBoundary
+	}
+	after, err := file.Slice(position, end)
+	if err != nil {
+		return err
+	}
+	if bytes.IndexByte(after, '\n') == -1 {
+		return fmt.Errorf("%w: offset %d is inside a line longer than %d bytes", ErrLineTooLong, fileSize-position, MaxLineLength)
+	}
+
+	return ErrOffsetNotAtLineBoundary
+}
+
+func translateOpenError(fileName string, err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w: %s", ErrFileNotFound, fileName)
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, fileName)
+	}
+
+	return err
+}
@@ -0,0 +1,154 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Format is the structure of the lines in a log file
+type Format string
+
+const (
+	FormatJSON   Format = "json"
+	FormatLogfmt Format = "logfmt"
+	FormatSyslog Format = "syslog"
+	FormatCLF    Format = "clf"
+	FormatPlain  Format = "plain"
+)
+
+var (
+	// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+	clfPattern = regexp.MustCompile(`^\S+ \S+ \S+ \[[^\]]+\] "[^"]*" \d{3} (\d+|-)`)
+	// <34>1 2003-10-11T22:14:15.003Z mymachine su - ID47 - message (RFC 5424)
+	// <34>Oct 11 22:14:15 mymachine su: message (RFC 3164, the PRI is usually gone once it's written to a file)
+	syslogPattern = regexp.MustCompile(
+		`^(<\d{1,3}>\d \d{4}-\d\d-\d\dT\S+ \S+ |(<\d{1,3}>)?[A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d \S+ )`)
+	// key=value or key="quoted value"
+	logfmtPairPattern = regexp.MustCompile(`(?:^|\s)[A-Za-z_][\w.\-]*=(?:"(?:[^"\\]|\\.)*"|\S*)`)
+)
+
+// FORMAT_SAMPLE_SIZE is how many bytes from the start of a file are looked at to detect its format
+const FORMAT_SAMPLE_SIZE = 1 << 16
+
+// DetectLineFormat returns the format of a single line
+func DetectLineFormat(line string) Format {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)):
+		return FormatJSON
+	case clfPattern.MatchString(line):
+		return FormatCLF
+	case syslogPattern.MatchString(line):
+		return FormatSyslog
+	case len(logfmtPairPattern.FindAllStringIndex(line, 2)) == 2 && logfmtPairPattern.FindStringIndex(line)[0] == 0:
+		return FormatLogfmt
+	}
+
+	return FormatPlain
+}
+
+// DetectFormat returns the format most of lines are in, FormatPlain if there's no clear majority
+func DetectFormat(lines []string) Format {
+	counts := map[Format]int{}
+	total := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		counts[DetectLineFormat(line)]++
+		total++
+	}
+
+	for format, count := range counts {
+		if format != FormatPlain && count*2 > total {
+			return format
+		}
+	}
+
+	return FormatPlain
+}
+
+// DetectFileFormat detects the format of fileName from the lines at the beginning of the file
+func DetectFileFormat(fileName string) (Format, error) {
+	sample, err := sampleFile(fileName)
+	if err != nil {
+		return FormatPlain, err
+	}
+
+	return DetectFormat(sample.lines()), nil
+}
+
+// fileSample is the decompressed content at the start of a file
+type fileSample struct {
+	content     []byte
+	compression Compression
+	// how many bytes of the file on disk (compressed) it took to get content
+	consumed int64
+	// the whole file fit into the sample
+	complete bool
+}
+
+// lines returns the complete lines of the sample
+func (sample fileSample) lines() []string {
+	content := sample.content
+	if !sample.complete {
+		// the last line is cut off
+		content = content[:bytes.LastIndexByte(content, '\n')+1]
+	}
+
+	content = bytes.TrimSuffix(content, []byte{'\n'})
+	if len(content) == 0 {
+		return []string{}
+	}
+	return strings.Split(string(content), "\n")
+}
+
+// sampleFile reads the first FORMAT_SAMPLE_SIZE bytes of the (decompressed) content of fileName
+func sampleFile(fileName string) (fileSample, error) {
+	file, _, err := openForRead(fileName)
+	if err != nil {
+		return fileSample{}, err
+	}
+	defer file.Close()
+
+	compression, err := detectCompression(file)
+	if err != nil {
+		return fileSample{}, err
+	}
+
+	counter := &countingReader{reader: file}
+	reader, release, err := newDecompressor(counter, compression)
+	if err != nil {
+		return fileSample{}, err
+	}
+	if release != nil {
+		defer release()
+	}
+
+	content := make([]byte, FORMAT_SAMPLE_SIZE)
+	n, err := io.ReadFull(reader, content)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fileSample{}, err
+	}
+
+	return fileSample{
+		content:     content[:n],
+		compression: compression,
+		consumed:    counter.count,
+		complete:    n < FORMAT_SAMPLE_SIZE,
+	}, nil
+}
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.count += int64(n)
+	return n, err
+}
@@ -0,0 +1,253 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ForwardLineReader is BackwardLineReader the other way around, it reads the lines of a file oldest first.
+// lines end the same way and offsets are counted back from the end of the file the same way,
+// a line has the same offset whichever way it's read.
+// the bytes behind the last line break of the file aren't a line yet though, the writer can still be in the middle of it.
+// the reader stops in front of them, to read on from there once the line is complete
+type ForwardLineReader struct {
+	// BufferSize is how many bytes are read at once, ReadBufferSize unless changed before the first Next
+	BufferSize int
+
+	file Reader
+	// owned is true when the reader opened the file itself and Close closes it
+	owned bool
+	size  int64
+	// offset is where the next line starts
+	offset int64
+	// checkBoundary is set by Seek, the next Next makes sure the line before offset ends there
+	checkBoundary bool
+	// buf holds the bytes of the file from bufStart on that haven't been returned yet
+	buf      []byte
+	bufStart int64
+}
+
+// OpenForwardLineReader opens fileName with DefaultReaderBackend and reads it from the start, Close closes the file
+func OpenForwardLineReader(fileName string) (*ForwardLineReader, error) {
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := NewForwardLineReader(file, fileSize)
+	reader.owned = true
+	return reader, nil
+}
+
+// NewForwardLineReader reads the lines of file in front of fileSize, like NewBackwardLineReader.
+// the file stays the caller's, Close leaves it open
+func NewForwardLineReader(file Reader, fileSize int64) *ForwardLineReader {
+	return &ForwardLineReader{BufferSize: ReadBufferSize, file: file, size: fileSize, offset: fileSize}
+}
+
+// Next returns the next newer line and the offset it starts at,
+// io.EOF once the end of the last complete line has been reached. Offset is left there
+// ErrOffsetNotAtLineBoundary means Seek moved into the middle of a line, ErrLineTooLong into the middle of one longer than MaxLineLength.
+// the line is only valid until the next call to Next or Seek and must not be changed, like with BackwardLineReader
+func (reader *ForwardLineReader) Next() ([]byte, int64, error) {
+	if reader.offset <= 0 {
+		return nil, reader.offset, io.EOF
+	}
+
+	var line []byte
+	var lineEnd int64
+	err := guardFaults(reader.file, func() error {
+		start := reader.size - reader.offset
+		if reader.checkBoundary {
+			reader.checkBoundary = false
+			if start > 0 {
+				before, err := reader.file.Slice(start-1, start)
+				if err != nil {
+					return err
+				}
+				if before[0] != '\n' {
+					return boundaryError(reader.file, reader.size, start)
+				}
+			}
+		}
+
+		var err error
+		line, lineEnd, err = reader.readLine(start)
+		return err
+	})
+	if err != nil {
+		return nil, reader.offset, err
+	}
+
+	offset := reader.offset
+	reader.offset = reader.size - lineEnd
+	return line, offset, nil
+}
+
+// Seek moves the reader like io.Seeker, the next line is the one starting at the new position (counted from the start of the file).
+// to read on from a LineReturn.Offset, which is counted back from the end, seek to -offset from io.SeekEnd.
+// seeking to the end of the file makes Next return io.EOF
+func (reader *ForwardLineReader) Seek(offset int64, whence int) (int64, error) {
+	position := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		position += reader.size - reader.offset
+	case io.SeekEnd:
+		position += reader.size
+	default:
+		return 0, fmt.Errorf("seek: invalid whence %d", whence)
+	}
+	if position < 0 || position > reader.size {
+		return 0, ErrOffsetNotAtLineBoundary
+	}
+
+	reader.offset = reader.size - position
+	reader.checkBoundary = true
+	reader.buf = nil
+	return position, nil
+}
+
+// Offset is where the next line starts, counted back from the end like LineReturn.Offset.
+// 0 once all the lines have been read, unless the file ends in an incomplete line
+func (reader *ForwardLineReader) Offset() int64 {
+	return reader.offset
+}
+
+// Size is the size of the file the offsets are counted back from
+func (reader *ForwardLineReader) Size() int64 {
+	return reader.size
+}
+
+// Close closes the file if the reader opened it
+func (reader *ForwardLineReader) Close() error {
+	reader.buf = nil
+	if reader.owned {
+		return reader.file.Close()
+	}
+	return nil
+}
+
+// readLine returns the line starting at start and the position behind its line break, io.EOF if it has none yet.
+// if the buffer doesn't hold the whole line, it is doubled until it does or it reaches MaxLineLength
+func (reader *ForwardLineReader) readLine(start int64) ([]byte, int64, error) {
+	if start >= reader.bufStart && start < reader.bufStart+int64(len(reader.buf)) {
+		rest := reader.buf[start-reader.bufStart:]
+		if index := bytes.IndexByte(rest, '\n'); index != -1 {
+			return cutLine(rest[:index]), start + int64(index) + 1, nil
+		}
+	}
+
+	bufSize := reader.BufferSize
+	for {
+		end := start + int64(bufSize)
+		if end > reader.size {
+			end = reader.size
+		}
+
+		buf, err := reader.file.Slice(start, end)
+		if err != nil {
+			return nil, 0, err
+		}
+		reader.buf, reader.bufStart = buf, start
+
+		if index := bytes.IndexByte(buf, '\n'); index != -1 {
+			return cutLine(buf[:index]), start + int64(index) + 1, nil
+		}
+		// the last line of the file, still being written
+		if end == reader.size {
+			return nil, 0, io.EOF
+		}
+
+		// a line plus its line break needs MaxLineLength + 1 bytes
+		if bufSize >= MaxLineLength+1 {
+			return reader.readTruncatedLine(start)
+		}
+
+		bufSize *= 2
+		if bufSize > MaxLineLength+1 {
+			bufSize = MaxLineLength + 1
+		}
+	}
+}
+
+// readTruncatedLine returns the line starting at start cut off at MaxLineLength bytes,
+// and the position behind its line break. used for lines that don't fit into the read buffer
+func (reader *ForwardLineReader) readTruncatedLine(start int64) ([]byte, int64, error) {
+	// look forward for the line break behind the line, chunk by chunk
+	lineEnd := int64(-1)
+	for chunkStart := start + int64(MaxLineLength); chunkStart < reader.size; chunkStart += int64(ReadBufferSize) {
+		chunkEnd := chunkStart + int64(ReadBufferSize)
+		if chunkEnd > reader.size {
+			chunkEnd = reader.size
+		}
+
+		chunk, err := reader.file.Slice(chunkStart, chunkEnd)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if index := bytes.IndexByte(chunk, '\n'); index != -1 {
+			lineEnd = chunkStart + int64(index)
+			break
+		}
+	}
+
+	// the last line of the file, still being written
+	if lineEnd == -1 {
+		return nil, 0, io.EOF
+	}
+
+	head, err := reader.file.Slice(start, start+int64(MaxLineLength))
+	if err != nil {
+		return nil, 0, err
+	}
+	reader.buf = nil
+
+	line := append(head[:MaxLineLength:MaxLineLength], fmt.Sprintf(TRUNCATED_LINE_MARKER, lineEnd-start-int64(MaxLineLength))...)
+	return line, lineEnd + 1, nil
+}
+
+// collectForwardLines reads lines from reader until we reach n lines that matcher matches
+// or the lines don't fit into budget anymore.
+// returns the lines oldest first, the offset the next read should start from
+// and whether there are complete lines behind it already
+func collectForwardLines(reader *ForwardLineReader, n int, matcher Matcher, budget *resultBudget) ([]LineReturn, int64, bool, error) {
+	lines := []LineReturn{}
+	next := int64(0)
+	more := false
+	err := guardFaults(reader.file, func() error {
+		for {
+			next = reader.Offset()
+			line, offset, err := reader.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			// the line behind the nth one is only read to tell whether there is one
+			if len(lines) == n {
+				more = true
+				return nil
+			}
+
+			text := string(line)
+			if !isMatchAll(matcher) && !matcher.Match(text) {
+				continue
+			}
+			// the next read starts with the line that didn't fit
+			if !budget.take(len(text)) {
+				more = true
+				return nil
+			}
+			lines = append(lines, LineReturn{Line: text, Offset: offset})
+		}
+	})
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	return lines, next, more, nil
+}
@@ -0,0 +1,227 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"os"
+	"strings"
+)
+
+var _ = Describe("ForwardLineReader", func() {
+	// readAll returns the lines the reader has left, oldest first
+	readAll := func(reader *file.ForwardLineReader) []file.LineReturn {
+		lines := []file.LineReturn{}
+		for {
+			line, offset, err := reader.Next()
+			if err == io.EOF {
+				return lines
+			}
+			Expect(err).To(BeNil())
+			lines = append(lines, file.LineReturn{Line: string(line), Offset: offset})
+		}
+	}
+
+	open := func(fileName string) *file.ForwardLineReader {
+		reader, err := file.OpenForwardLineReader(fileName)
+		Expect(err).To(BeNil())
+		DeferCleanup(reader.Close)
+		return reader
+	}
+
+	It("returns the lines of the backward reader in reverse, with the same offsets", func() {
+		fileName := writeTestLines(2000)
+		reader := open(fileName)
+		reader.BufferSize = 256
+
+		backward, err := file.ReadLastLinesWithOffsetPagination(fileName, 0, 1<<20)
+		Expect(err).To(BeNil())
+		lines := readAll(reader)
+		Expect(lines).To(HaveLen(len(backward)))
+		for i, line := range lines {
+			Expect(line).To(Equal(backward[len(backward)-1-i]))
+		}
+		Expect(reader.Offset()).To(Equal(int64(0)))
+	})
+
+	It("reads on from a LineReturn offset", func() {
+		fileName := writeTestLines(100)
+		reader := open(fileName)
+
+		backward, err := file.ReadLastLinesWithOffsetPagination(fileName, 0, 1<<20)
+		Expect(err).To(BeNil())
+
+		_, err = reader.Seek(-backward[10].Offset, io.SeekEnd)
+		Expect(err).To(BeNil())
+		line, offset, err := reader.Next()
+		Expect(err).To(BeNil())
+		Expect(string(line)).To(Equal("Line 90 of the test file"))
+		Expect(offset).To(Equal(backward[10].Offset))
+		line, _, err = reader.Next()
+		Expect(err).To(BeNil())
+		Expect(string(line)).To(Equal("Line 91 of the test file"))
+	})
+
+	It("rejects offsets in the middle of a line or outside of the file", func() {
+		reader := open(writeTestLines(10))
+
+		_, err := reader.Seek(-5, io.SeekEnd)
+		Expect(err).To(BeNil())
+		_, _, err = reader.Next()
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+
+		_, err = reader.Seek(-reader.Size()-1, io.SeekEnd)
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+		_, err = reader.Seek(1, io.SeekEnd)
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+	})
+
+	It("can't read on from the middle of a line longer than MaxLineLength", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 100
+
+		reader := open(writeTestFile("short\n" + strings.Repeat("a", 250) + "\nend\n"))
+
+		_, err := reader.Seek(200, io.SeekStart)
+		Expect(err).To(BeNil())
+		_, _, err = reader.Next()
+		Expect(err).To(MatchError(file.ErrLineTooLong))
+
+		_, err = reader.Seek(3, io.SeekStart)
+		Expect(err).To(BeNil())
+		_, _, err = reader.Next()
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+	})
+
+	It("strips CRLF line breaks and stops in front of a last line without a line break", func() {
+		reader := open(writeTestFile("first\r\nsecond\r\n\r\nlast"))
+
+		Expect(readAll(reader)).To(Equal([]file.LineReturn{
+			{Line: "first", Offset: 21},
+			{Line: "second", Offset: 14},
+			{Line: "", Offset: 6},
+		}))
+		Expect(reader.Offset()).To(Equal(int64(4)))
+	})
+
+	It("grows the buffer for long lines and truncates the ones over MaxLineLength", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 300
+
+		long := strings.Repeat("a", 250)
+		tooLong := strings.Repeat("b", 400)
+		reader := open(writeTestFile("short\n" + tooLong + "\n" + long + "\n" + tooLong))
+		reader.BufferSize = 16
+
+		lines := readAll(reader)
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(Equal(file.LineReturn{Line: "short", Offset: reader.Size()}))
+		Expect(lines[1].Line).To(Equal(strings.Repeat("b", 300) + "...[truncated 100 bytes]"))
+		Expect(lines[2]).To(Equal(file.LineReturn{Line: long, Offset: 251 + 400}))
+		// the long line at the end hasn't been finished yet
+		Expect(reader.Offset()).To(Equal(int64(400)))
+	})
+
+	It("reads nothing from an empty file", func() {
+		reader := open(writeTestFile(""))
+
+		_, _, err := reader.Next()
+		Expect(err).To(Equal(io.EOF))
+	})
+})
+
+var _ = Describe("ReadPageForward", func() {
+	// start returns the cursor of the first forward page from offset
+	start := func(fileName string, offset int64) file.Cursor {
+		identity, err := file.GetFileIdentity(fileName)
+		Expect(err).To(BeNil())
+		return file.Cursor{Offset: offset, Device: identity.Device, Inode: identity.Inode, Size: identity.Size, Forward: true}
+	}
+
+	It("reads forward from an offset of a backward page, across appends", func() {
+		fileName := writeTestFile("one\ntwo\nthree\nfour\n")
+
+		backward, own, fileSize, _, err := file.ReadPageWithOffsets(fileName, 3, file.MatchAll, nil)
+		Expect(err).To(BeNil())
+		Expect(own).To(Equal(3))
+		Expect(fileSize).To(Equal(int64(19)))
+		Expect(backward[2].Line).To(Equal("two"))
+
+		cursor := start(fileName, backward[2].Offset)
+		// the offset stays counted back from the size the page had
+		appendToFile(fileName, "five\n")
+
+		lines, next, hasMore, err := file.ReadPageForward(fileName, 2, file.MatchAll, cursor)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]file.LineReturn{{Line: "two", Offset: 20}, {Line: "three", Offset: 16}}))
+		Expect(hasMore).To(BeTrue())
+
+		lines, next, hasMore, err = file.ReadPageForward(fileName, 10, file.MatchAll, *next)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]file.LineReturn{{Line: "four", Offset: 10}, {Line: "five", Offset: 5}}))
+		Expect(hasMore).To(BeFalse())
+		Expect(next.Offset).To(Equal(int64(0)))
+
+		// the cursor at the end picks up what's appended later
+		appendToFile(fileName, "six\n")
+		lines, _, _, err = file.ReadPageForward(fileName, 10, file.KeywordMatcher("i"), *next)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]file.LineReturn{{Line: "six", Offset: 4}}))
+	})
+
+	It("waits for the last line to be finished", func() {
+		fileName := writeTestFile("one\ntwo\nthr")
+
+		lines, next, hasMore, err := file.ReadPageForward(fileName, 10, file.MatchAll, start(fileName, 11))
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]file.LineReturn{{Line: "one", Offset: 11}, {Line: "two", Offset: 7}}))
+		Expect(hasMore).To(BeFalse())
+		Expect(next.Offset).To(Equal(int64(3)))
+
+		appendToFile(fileName, "ee\nfour\n")
+		lines, next, hasMore, err = file.ReadPageForward(fileName, 1, file.MatchAll, *next)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]file.LineReturn{{Line: "three", Offset: 11}}))
+		Expect(hasMore).To(BeTrue())
+
+		lines, _, hasMore, err = file.ReadPageForward(fileName, 1, file.MatchAll, *next)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]file.LineReturn{{Line: "four", Offset: 5}}))
+		Expect(hasMore).To(BeFalse())
+	})
+
+	It("doesn't take the cursors of the other direction", func() {
+		fileName := writeTestLines(10)
+
+		_, backward, err := file.ReadPage(fileName, 2, file.MatchAll, nil)
+		Expect(err).To(BeNil())
+		_, _, _, err = file.ReadPageForward(fileName, 2, file.MatchAll, *backward)
+		Expect(err).To(Equal(file.ErrInvalidCursor))
+
+		forward := start(fileName, 0)
+		_, _, err = file.ReadPage(fileName, 2, file.MatchAll, &forward)
+		Expect(err).To(Equal(file.ErrInvalidCursor))
+	})
+
+	It("rejects the cursor once the file has been rotated", func() {
+		fileName := writeTestLines(10)
+		cursor := start(fileName, 0)
+
+		Expect(os.Rename(fileName, fileName+".1")).To(Succeed())
+		Expect(os.WriteFile(fileName, []byte("new\n"), 0644)).To(Succeed())
+
+		_, _, _, err := file.ReadPageForward(fileName, 2, file.MatchAll, cursor)
+		Expect(err).To(Equal(file.ErrCursorFileChanged))
+	})
+
+	It("only counts the lines of the file itself as its own", func() {
+		fileName := writeTestFile("current 1\ncurrent 2\n")
+		Expect(os.WriteFile(fileName+".1", []byte("first 1\nfirst 2\n"), 0644)).To(Succeed())
+
+		lines, own, _, _, err := file.ReadPageWithOffsets(fileName, 3, file.MatchAll, nil)
+		Expect(err).To(BeNil())
+		Expect(lines).To(HaveLen(3))
+		Expect(own).To(Equal(2))
+	})
+})
@@ -0,0 +1,39 @@
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileIdentity identifies a file on disk independent of its name
+// Device and Inode stay the same when the file is renamed (rotated), Size tells us how far it has grown
+type FileIdentity struct {
+	Device uint64
+	Inode  uint64
+	Size   int64
+}
+
+// SameFile reports whether the two identities refer to the same file, regardless of its size
+func (identity FileIdentity) SameFile(other FileIdentity) bool {
+	return identity.Device == other.Device && identity.Inode == other.Inode
+}
+
+// GetFileIdentity returns the inode and the current size of fileName
+func GetFileIdentity(fileName string) (FileIdentity, error) {
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return FileIdentity{}, translateOpenError(fileName, err)
+	}
+
+	return identityFromFileInfo(stat), nil
+}
+
+func identityFromFileInfo(stat os.FileInfo) FileIdentity {
+	identity := FileIdentity{Size: stat.Size()}
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		identity.Device = uint64(sys.Dev)
+		identity.Inode = uint64(sys.Ino)
+	}
+
+	return identity
+}
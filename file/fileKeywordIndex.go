@@ -0,0 +1,434 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// KeywordIndexDir is where the keyword indexes are kept, one file per indexed log file.
+// empty (the default) turns them off. files are only indexed when BuildKeywordIndex is called for them,
+// set it before serving any requests, like ReadBufferSize
+var KeywordIndexDir = ""
+
+// KEYWORD_INDEX_BUCKETS is how many buckets the trigrams are hashed into.
+// the index takes KEYWORD_INDEX_BUCKETS bits per chunk, 2KB for every ParallelChunkSize bytes of the file.
+// fewer buckets share more trigrams and rule out fewer chunks
+const (
+	KEYWORD_INDEX_BUCKET_BITS = 14
+	KEYWORD_INDEX_BUCKETS     = 1 << KEYWORD_INDEX_BUCKET_BITS
+)
+
+// keywordIndex knows which trigrams (three bytes in a row, ASCII lowered) the lines of each chunk of a file have.
+// chunk c is the ChunkSize bytes starting at c*ChunkSize, the chunks of ReadLastNLinesMatchingP.
+// a line belongs to every chunk it has bytes in. only the first Chunks chunks are indexed,
+// the line holding the first byte of chunk Chunks starts at Part.Size, indexing carries on from there
+type keywordIndex struct {
+	ChunkSize int
+	Part      indexedPart
+	Chunks    int64
+	// Buckets[b] has bit c set when a line of chunk c has a trigram hashing to b
+	Buckets [][]uint64
+	// NonASCII has bit c set when chunk c has bytes outside of ASCII,
+	// lower casing those can turn them into ASCII letters the index hasn't seen
+	NonASCII  []uint64
+	UpdatedAt time.Time
+}
+
+// keywordIndexer is KeywordIndexDir and ParallelChunkSize, taken when a read or a build starts
+type keywordIndexer struct {
+	dir       string
+	chunkSize int
+}
+
+func currentKeywordIndexer() keywordIndexer {
+	return keywordIndexer{dir: KeywordIndexDir, chunkSize: ParallelChunkSize}
+}
+
+// keywordIndexes are the keyword indexes of the files read or built so far, by file name.
+// an index in here is never changed, a build replaces it with an extended copy
+var keywordIndexes = struct {
+	sync.Mutex
+	indexes  map[string]*keywordIndex
+	building map[string]bool
+}{indexes: map[string]*keywordIndex{}, building: map[string]bool{}}
+
+// BuildKeywordIndex indexes the chunks of fileName that aren't indexed yet and saves the index in KeywordIndexDir.
+// a chunk is indexed once the file has complete lines up to its end, so the lines in it don't change anymore.
+// the index is started over if the file has been replaced (its inode changed) or truncated.
+// only one build per file runs at a time, a build already running for fileName makes this a no-op
+func BuildKeywordIndex(fileName string) error {
+	return currentKeywordIndexer().build(fileName)
+}
+
+func (indexer keywordIndexer) build(fileName string) error {
+	if indexer.dir == "" || isCompressed(fileName) {
+		return nil
+	}
+
+	keywordIndexes.Lock()
+	if keywordIndexes.building[fileName] {
+		keywordIndexes.Unlock()
+		return nil
+	}
+	keywordIndexes.building[fileName] = true
+	keywordIndexes.Unlock()
+
+	defer func() {
+		keywordIndexes.Lock()
+		delete(keywordIndexes.building, fileName)
+		keywordIndexes.Unlock()
+	}()
+
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	identity := identityFromFileInfo(stat)
+
+	index := indexer.current(fileName, file, identity)
+	if index == nil {
+		index = &keywordIndex{
+			ChunkSize: indexer.chunkSize,
+			Part:      indexedPart{Device: identity.Device, Inode: identity.Inode},
+			Buckets:   make([][]uint64, KEYWORD_INDEX_BUCKETS),
+		}
+	}
+
+	// the chunks in front of the end of the last complete line are done
+	end := int64(0)
+	err = scanLineBreaksBackward(file, index.Part.Size, fileSize, func(position int64) bool {
+		end = position + 1
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	chunks := end / int64(index.ChunkSize)
+	if chunks <= index.Chunks {
+		return nil
+	}
+
+	// work on a copy, readers might be using the current one
+	index = index.extended(chunks)
+	if index.Part.Size, err = index.scan(file, end, chunks); err != nil {
+		return err
+	}
+	if index.Part.Checksum, err = indexChecksum(file, index.Part.Size); err != nil {
+		return err
+	}
+	index.Chunks = chunks
+	index.UpdatedAt = time.Now()
+
+	keywordIndexes.Lock()
+	keywordIndexes.indexes[fileName] = index
+	keywordIndexes.Unlock()
+
+	return indexer.save(fileName, index)
+}
+
+// extended copies index with room for chunks chunks
+func (index *keywordIndex) extended(chunks int64) *keywordIndex {
+	words := int((chunks + 63) / 64)
+	copied := *index
+	copied.Buckets = make([][]uint64, len(index.Buckets))
+	for bucket, bitmap := range index.Buckets {
+		copied.Buckets[bucket] = make([]uint64, words)
+		copy(copied.Buckets[bucket], bitmap)
+	}
+	copied.NonASCII = make([]uint64, words)
+	copy(copied.NonASCII, index.NonASCII)
+	return &copied
+}
+
+// scan adds the trigrams of the lines from Part.Size on to the chunks from Chunks up to chunks,
+// end is right behind a line break at or behind the end of chunk chunks-1.
+// returns where the line holding the first byte of chunk chunks starts
+func (index *keywordIndex) scan(file Reader, end int64, chunks int64) (int64, error) {
+	chunkSize := int64(index.ChunkSize)
+	boundary := chunks * chunkSize
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, index.Part.Size, end-index.Part.Size), ReadBufferSize)
+
+	buckets := []int{}
+	lineStart := index.Part.Size
+	for lineStart < boundary {
+		// the line is read in pieces when it doesn't fit into the reader's buffer,
+		// the last two bytes of a piece start the trigrams of the next one
+		buckets = buckets[:0]
+		nonASCII := false
+		var previous [2]byte
+		length := 0
+		position := lineStart
+		for {
+			piece, err := reader.ReadSlice('\n')
+			position += int64(len(piece))
+			if err != nil && err != bufio.ErrBufferFull {
+				// the lines up to end are complete, they can't run into the end of the file
+				return 0, err
+			}
+			lineBreak := err == nil
+			if lineBreak {
+				piece = piece[:len(piece)-1]
+			}
+
+			for _, b := range piece {
+				if b >= utf8.RuneSelf {
+					nonASCII = true
+				}
+				b = lowerASCII(b)
+				if length >= 2 {
+					buckets = append(buckets, trigramBucket(previous[0], previous[1], b))
+				}
+				previous[0], previous[1] = previous[1], b
+				length++
+			}
+			if lineBreak {
+				break
+			}
+		}
+
+		// the line ends with the line break at position-1, the chunks in front of Chunks have it already
+		first, last := lineStart/chunkSize, (position-1)/chunkSize
+		if first < index.Chunks {
+			first = index.Chunks
+		}
+		if last >= chunks {
+			last = chunks - 1
+		}
+		for chunk := first; chunk <= last; chunk++ {
+			word, bit := chunk/64, uint64(1)<<(chunk%64)
+			for _, bucket := range buckets {
+				index.Buckets[bucket][word] |= bit
+			}
+			if nonASCII {
+				index.NonASCII[word] |= bit
+			}
+		}
+
+		if position > boundary {
+			return lineStart, nil
+		}
+		lineStart = position
+	}
+
+	return lineStart, nil
+}
+
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+func trigramBucket(a byte, b byte, c byte) int {
+	trigram := uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+	// fibonacci hashing, the top bits of the product are the bucket
+	return int(trigram * 2654435769 >> (32 - KEYWORD_INDEX_BUCKET_BITS))
+}
+
+// keywordBuckets are the buckets of the trigrams of keyword that are all ASCII,
+// lower casing a line (the case insensitive keywords) leaves its ASCII bytes where they are
+func keywordBuckets(keyword string) []int {
+	buckets := []int{}
+	for i := 0; i+3 <= len(keyword); i++ {
+		if keyword[i] >= utf8.RuneSelf || keyword[i+1] >= utf8.RuneSelf || keyword[i+2] >= utf8.RuneSelf {
+			continue
+		}
+		buckets = append(buckets, trigramBucket(lowerASCII(keyword[i]), lowerASCII(keyword[i+1]), lowerASCII(keyword[i+2])))
+	}
+	return buckets
+}
+
+// candidates returns whether a chunk can have a line matcher matches, the chunks behind the index always can.
+// nil when the index can't tell for matcher: regular expressions, Not and keywords shorter than a trigram.
+// an And needs one of its matchers to be ruled out by the index, an Or all of them
+func (index *keywordIndex) candidates(matcher Matcher) func(chunk int64) bool {
+	switch matcher := matcher.(type) {
+	case keywordMatcher:
+		buckets := keywordBuckets(matcher.keyword)
+		if len(buckets) == 0 {
+			return nil
+		}
+		return func(chunk int64) bool {
+			if chunk >= index.Chunks || matcher.ignoreCase && hasBit(index.NonASCII, chunk) {
+				return true
+			}
+			for _, bucket := range buckets {
+				if !hasBit(index.Buckets[bucket], chunk) {
+					return false
+				}
+			}
+			return true
+		}
+
+	case andMatcher:
+		filters := []func(chunk int64) bool{}
+		for _, term := range matcher {
+			if filter := index.candidates(term); filter != nil {
+				filters = append(filters, filter)
+			}
+		}
+		if len(filters) == 0 {
+			return nil
+		}
+		return func(chunk int64) bool {
+			for _, filter := range filters {
+				if !filter(chunk) {
+					return false
+				}
+			}
+			return true
+		}
+
+	case orMatcher:
+		filters := []func(chunk int64) bool{}
+		for _, term := range matcher {
+			filter := index.candidates(term)
+			if filter == nil {
+				return nil
+			}
+			filters = append(filters, filter)
+		}
+		return func(chunk int64) bool {
+			for _, filter := range filters {
+				if filter(chunk) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	return nil
+}
+
+func hasBit(bitmap []uint64, bit int64) bool {
+	return bitmap[bit/64]&(uint64(1)<<(bit%64)) != 0
+}
+
+// chunkFilter returns whether chunk (counted from the start of the file) of chunkSize bytes can have a line matcher matches,
+// nil when all of them can: there's no valid index of fileName, or it has other chunks or can't tell for matcher
+func (indexer keywordIndexer) chunkFilter(fileName string, file Reader, chunkSize int, matcher Matcher) func(chunk int64) bool {
+	if indexer.dir == "" || chunkSize != indexer.chunkSize || isMatchAll(matcher) {
+		return nil
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	index := indexer.current(fileName, file, identityFromFileInfo(stat))
+	if index == nil {
+		return nil
+	}
+	return index.candidates(matcher)
+}
+
+// current returns the index of fileName if it's still valid for the file, nil if there is none.
+// it's loaded from the index dir the first time fileName is asked for
+func (indexer keywordIndexer) current(fileName string, file Reader, identity FileIdentity) *keywordIndex {
+	keywordIndexes.Lock()
+	index, found := keywordIndexes.indexes[fileName]
+	keywordIndexes.Unlock()
+
+	if !found {
+		index = indexer.load(fileName)
+	}
+	if index == nil || index.ChunkSize != indexer.chunkSize || len(index.Buckets) != KEYWORD_INDEX_BUCKETS ||
+		!index.Part.validFor(file, identity) {
+		return nil
+	}
+
+	if !found {
+		keywordIndexes.Lock()
+		keywordIndexes.indexes[fileName] = index
+		keywordIndexes.Unlock()
+	}
+	return index
+}
+
+// load reads the saved index of fileName, nil if there is none or it can't be read
+func (indexer keywordIndexer) load(fileName string) *keywordIndex {
+	content, err := os.ReadFile(indexPath(indexer.dir, fileName, ".keywordindex"))
+	if err != nil {
+		return nil
+	}
+
+	index := &keywordIndex{}
+	if gob.NewDecoder(bytes.NewReader(content)).Decode(index) != nil {
+		return nil
+	}
+	return index
+}
+
+func (indexer keywordIndexer) save(fileName string, index *keywordIndex) error {
+	content := bytes.Buffer{}
+	if err := gob.NewEncoder(&content).Encode(index); err != nil {
+		return err
+	}
+	return writeIndexFile(indexPath(indexer.dir, fileName, ".keywordindex"), content.Bytes())
+}
+
+// IndexStatus is how far the indexes of a file have got
+type IndexStatus struct {
+	Size int64 `json:"size"`
+	// LineIndexed and KeywordIndexed are how many bytes from the start of the file each index covers,
+	// 0 when the index is off or hasn't been built yet
+	LineIndexed    int64 `json:"line_indexed"`
+	KeywordIndexed int64 `json:"keyword_indexed"`
+	// Building is true while an index of the file is being extended
+	Building bool `json:"building"`
+	// KeywordUpdatedAt is when the keyword index was last extended
+	KeywordUpdatedAt *time.Time `json:"keyword_updated_at,omitempty"`
+}
+
+// FileIndexStatus tells how much of fileName the line index and the keyword index cover
+func FileIndexStatus(fileName string) (IndexStatus, error) {
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return IndexStatus{}, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return IndexStatus{}, err
+	}
+	identity := identityFromFileInfo(stat)
+
+	status := IndexStatus{Size: fileSize}
+	if isCompressed(fileName) {
+		return status, nil
+	}
+
+	if indexer := currentLineIndexer(); indexer.dir != "" {
+		status.LineIndexed = indexer.current(fileName, file, identity).Size
+	}
+	if indexer := currentKeywordIndexer(); indexer.dir != "" {
+		if index := indexer.current(fileName, file, identity); index != nil {
+			status.KeywordIndexed = index.Chunks * int64(index.ChunkSize)
+			status.KeywordUpdatedAt = &index.UpdatedAt
+		}
+	}
+
+	lineIndexes.Lock()
+	status.Building = lineIndexes.building[fileName]
+	lineIndexes.Unlock()
+	keywordIndexes.Lock()
+	status.Building = status.Building || keywordIndexes.building[fileName]
+	keywordIndexes.Unlock()
+
+	return status, nil
+}
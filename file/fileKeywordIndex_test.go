@@ -0,0 +1,145 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("Keyword index", func() {
+	var fileName string
+
+	matchers := map[string]file.Matcher{
+		"keyword":          file.KeywordMatcher("error"),
+		"case insensitive": file.CaseInsensitiveKeywordMatcher("ERROR"),
+		"nowhere":          file.KeywordMatcher("nowhere to be found"),
+		"or":               file.Or(file.KeywordMatcher("error"), file.KeywordMatcher("spans")),
+		"and not":          file.And(file.KeywordMatcher("error"), file.Not(file.KeywordMatcher("7 "))),
+		"short":            file.KeywordMatcher("9"),
+	}
+
+	// read returns the lines of every matcher, with or without the index
+	read := func(indexed bool) map[string][]string {
+		dir := file.KeywordIndexDir
+		if !indexed {
+			file.KeywordIndexDir = ""
+			defer func() { file.KeywordIndexDir = dir }()
+		}
+
+		result := map[string][]string{}
+		for name, matcher := range matchers {
+			lines, err := file.ReadLastNLinesMatchingP(fileName, 30, matcher)
+			Expect(err).To(BeNil())
+			result[name] = []string{}
+			for _, line := range lines {
+				result[name] = append(result[name], string(line))
+			}
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		dir, err := os.MkdirTemp("", "logmonitor-index")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, dir)
+
+		file.KeywordIndexDir = dir
+		file.ParallelChunkSize = 64
+		DeferCleanup(func() {
+			file.KeywordIndexDir = ""
+			file.ParallelChunkSize = file.FILE_OFFSET_UNIT_SIZE
+		})
+
+		// a few errors among many other lines, and a line over several chunks with the keyword at its end
+		lines := []string{}
+		for i := 1; i <= 1000; i++ {
+			switch {
+			case i%97 == 0:
+				lines = append(lines, fmt.Sprintf("Line %d has an error", i))
+			case i == 500:
+				lines = append(lines, "Line 500 spans "+strings.Repeat("-", 300)+" ERROR")
+			default:
+				lines = append(lines, fmt.Sprintf("Line %d of the test file", i))
+			}
+		}
+		fileName = writeTestFile(strings.Join(lines, "\n") + "\n")
+	})
+
+	It("finds the same lines through the index", func() {
+		unindexed := read(false)
+		Expect(unindexed["keyword"]).To(HaveLen(10))
+		Expect(unindexed["case insensitive"]).To(ContainElement(ContainSubstring("Line 500 spans")))
+
+		Expect(file.BuildKeywordIndex(fileName)).To(Succeed())
+		saved, err := filepath.Glob(filepath.Join(file.KeywordIndexDir, "*.keywordindex"))
+		Expect(err).To(BeNil())
+		Expect(saved).To(HaveLen(1))
+
+		Expect(read(true)).To(Equal(unindexed))
+	})
+
+	It("doesn't read the chunks the index rules out", func() {
+		Expect(file.BuildKeywordIndex(fileName)).To(Succeed())
+
+		// the same number of bytes far enough from the end to keep the checksum, the index doesn't know about it
+		content, err := os.ReadFile(fileName)
+		Expect(err).To(BeNil())
+		Expect(os.WriteFile(fileName, []byte(strings.Replace(string(content), "Line 3 of the test file", "Line 3 of the test zzzz", 1)), 0644)).To(Succeed())
+
+		lines, err := file.ReadLastNLinesMatchingP(fileName, 10, file.KeywordMatcher("zzzz"))
+		Expect(err).To(BeNil())
+		Expect(lines).To(BeEmpty())
+
+		file.KeywordIndexDir = ""
+		lines, err = file.ReadLastNLinesMatchingP(fileName, 10, file.KeywordMatcher("zzzz"))
+		Expect(err).To(BeNil())
+		Expect(lines).To(HaveLen(1))
+	})
+
+	It("extends the index as the file grows", func() {
+		Expect(file.BuildKeywordIndex(fileName)).To(Succeed())
+		before, err := file.FileIndexStatus(fileName)
+		Expect(err).To(BeNil())
+		Expect(before.KeywordIndexed).To(BeNumerically(">", before.Size-64))
+
+		// the first line goes into the chunks indexed so far, the index has to carry on where it stopped
+		for i := 1; i <= 20; i++ {
+			appendToFile(fileName, fmt.Sprintf("Appended %d with an error\n", i))
+		}
+		Expect(read(true)).To(Equal(read(false)))
+
+		Expect(file.BuildKeywordIndex(fileName)).To(Succeed())
+		after, err := file.FileIndexStatus(fileName)
+		Expect(err).To(BeNil())
+		Expect(after.KeywordIndexed).To(BeNumerically(">", before.KeywordIndexed))
+		Expect(read(true)).To(Equal(read(false)))
+	})
+
+	It("starts over when the file is truncated or replaced", func() {
+		Expect(file.BuildKeywordIndex(fileName)).To(Succeed())
+
+		// same inode, fewer lines
+		Expect(os.WriteFile(fileName, []byte("first error\nsecond\nthird error\n"), 0644)).To(Succeed())
+		Expect(read(true)).To(Equal(read(false)))
+		status, err := file.FileIndexStatus(fileName)
+		Expect(err).To(BeNil())
+		Expect(status.KeywordIndexed).To(BeZero())
+
+		// another file moved in its place
+		replacement := filepath.Join(filepath.Dir(fileName), "replacement.log")
+		content := ""
+		for i := 1; i <= 200; i++ {
+			content += fmt.Sprintf("Replaced %d error\n", i)
+		}
+		Expect(os.WriteFile(replacement, []byte(content), 0644)).To(Succeed())
+		Expect(os.Rename(replacement, fileName)).To(Succeed())
+		Expect(read(true)).To(Equal(read(false)))
+
+		Expect(file.BuildKeywordIndex(fileName)).To(Succeed())
+		Expect(read(true)).To(Equal(read(false)))
+	})
+})
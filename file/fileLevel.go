@@ -0,0 +1,135 @@
+package file
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Level is the severity of a log line, a higher level is more severe
+type Level int
+
+const (
+	LevelUnknown Level = iota
+	LevelTrace
+	LevelDebug
+	LevelInfo
+	LevelWarn
+	LevelError
+	LevelFatal
+)
+
+var levelNames = []string{"unknown", "trace", "debug", "info", "warn", "error", "fatal"}
+
+func (level Level) String() string {
+	if level < LevelUnknown || level > LevelFatal {
+		return levelNames[LevelUnknown]
+	}
+	return levelNames[level]
+}
+
+func (level Level) MarshalText() ([]byte, error) {
+	return []byte(level.String()), nil
+}
+
+// levelAliases are the names loggers use for the levels, lower case
+var levelAliases = map[string]Level{
+	"trace": LevelTrace, "trc": LevelTrace, "t": LevelTrace, "finest": LevelTrace, "finer": LevelTrace,
+	"debug": LevelDebug, "dbg": LevelDebug, "d": LevelDebug, "fine": LevelDebug,
+	"info": LevelInfo, "inf": LevelInfo, "i": LevelInfo, "information": LevelInfo, "notice": LevelInfo,
+	"warn": LevelWarn, "wrn": LevelWarn, "w": LevelWarn, "warning": LevelWarn,
+	"error": LevelError, "err": LevelError, "e": LevelError, "severe": LevelError,
+	"fatal": LevelFatal, "f": LevelFatal, "crit": LevelFatal, "critical": LevelFatal,
+	"alert": LevelFatal, "emerg": LevelFatal, "emergency": LevelFatal, "panic": LevelFatal,
+}
+
+// ParseLevel turns the name of a level (warn, WARNING, E...) into a Level
+func ParseLevel(name string) (Level, bool) {
+	level, ok := levelAliases[strings.ToLower(strings.TrimSpace(name))]
+	return level, ok
+}
+
+// syslogLevels maps the severity of a syslog priority to a Level
+var syslogLevels = []Level{LevelFatal, LevelFatal, LevelFatal, LevelError, LevelWarn, LevelInfo, LevelInfo, LevelDebug}
+
+var (
+	// level=error, lvl="warn", severity=INFO in logfmt or similar
+	levelPairPattern = regexp.MustCompile(`(?i)(?:^|[\s,{])"?(?:level|lvl|severity|loglevel)"?\s*[=:]\s*"?([A-Za-z]+)`)
+	// [ERROR], [E], [warn]
+	levelBracketPattern = regexp.MustCompile(`\[([A-Za-z]{1,11})\]`)
+	// E1017 14:02:03.123456 (glog)
+	glogPattern = regexp.MustCompile(`^([IWEF])\d{4} \d\d:\d\d:\d\d`)
+	// ERROR, WARNING... as a word on its own, upper case only so that "no error" in a message doesn't count
+	levelWordPattern = regexp.MustCompile(`\b(TRACE|DEBUG|INFO|NOTICE|WARN|WARNING|ERROR|ERR|SEVERE|CRIT|CRITICAL|FATAL|PANIC|ALERT|EMERG)\b`)
+)
+
+// LevelClassifier finds the severity of a line, LevelUnknown if it can't tell
+type LevelClassifier func(line string) Level
+
+// ClassifyLevel is the default LevelClassifier. it looks for, in this order
+//
+//	the level (or severity) field of json lines
+//	the syslog priority (<34>...)
+//	level=error style pairs
+//	[ERROR] or [E] in brackets, glog's E1017 prefix
+//	ERROR, WARN... as upper case words
+//	the status of access logs (5xx error, 4xx warn)
+func ClassifyLevel(line string) Level {
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "{") {
+		if parsed := ParseLine(trimmed, FormatJSON); !parsed.Raw {
+			if level, ok := ParseLevel(parsed.Level); ok {
+				return level
+			}
+		}
+	}
+
+	if strings.HasPrefix(line, "<") {
+		if end := strings.IndexByte(line, '>'); end > 1 && end <= 4 {
+			if priority, err := strconv.Atoi(line[1:end]); err == nil && priority >= 0 {
+				return syslogLevels[priority%8]
+			}
+		}
+	}
+
+	if match := levelPairPattern.FindStringSubmatch(line); match != nil {
+		if level, ok := ParseLevel(match[1]); ok {
+			return level
+		}
+	}
+
+	for _, match := range levelBracketPattern.FindAllStringSubmatch(line, 3) {
+		if level, ok := ParseLevel(match[1]); ok {
+			return level
+		}
+	}
+
+	if match := glogPattern.FindStringSubmatch(line); match != nil {
+		level, _ := ParseLevel(match[1])
+		return level
+	}
+
+	if match := levelWordPattern.FindStringSubmatch(line); match != nil {
+		level, _ := ParseLevel(match[1])
+		return level
+	}
+
+	if clfPattern.MatchString(line) {
+		level, _ := ParseLevel(ParseLine(line, FormatCLF).Level)
+		return level
+	}
+
+	return LevelUnknown
+}
+
+// LevelMatcher matches lines of minimum severity or above according to classify
+// lines classify can't tell the level of don't match
+func LevelMatcher(minimum Level, classify LevelClassifier) Matcher {
+	if minimum <= LevelUnknown {
+		return MatchAll
+	}
+
+	return MatcherFunc(func(line string) bool {
+		return classify(line) >= minimum
+	})
+}
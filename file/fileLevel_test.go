@@ -0,0 +1,68 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"encoding/json"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ClassifyLevel", func() {
+	It("detects the level from the common patterns", func() {
+		for line, expected := range map[string]file.Level{
+			`{"level":"warning","msg":"slow"}`:                                  file.LevelWarn,
+			`{"severity":"ERROR","message":"failed"}`:                           file.LevelError,
+			`<11>Oct 17 14:02:03 host app: failed`:                              file.LevelError,
+			`<34>1 2026-10-17T14:02:03Z host su - ID47 - failed`:                file.LevelFatal,
+			`<14>Oct 17 14:02:03 host app: started`:                             file.LevelInfo,
+			`time=2026-10-17T14:02:03Z level=debug msg="cache miss"`:            file.LevelDebug,
+			`2026-10-17 14:02:03 [E] connection reset`:                          file.LevelError,
+			`2026-10-17 14:02:03 [main] [warn] disk almost full`:                file.LevelWarn,
+			`2026-10-17 14:02:03 ERROR connection reset`:                        file.LevelError,
+			`2026-10-17 14:02:03 WARNING disk almost full`:                      file.LevelWarn,
+			`E1017 14:02:03.123456 server.go:10] failed`:                        file.LevelError,
+			`127.0.0.1 - - [17/Oct/2026:14:02:03 +0000] "GET / HTTP/1.1" 503 0`: file.LevelError,
+			`Line 1 of the test file`:                                           file.LevelUnknown,
+			`2026-10-17 14:02:03 no error this time`:                            file.LevelUnknown,
+		} {
+			Expect(file.ClassifyLevel(line)).To(Equal(expected), line)
+		}
+	})
+
+	It("parses level names", func() {
+		level, ok := file.ParseLevel("WARNING")
+		Expect(ok).To(BeTrue())
+		Expect(level).To(Equal(file.LevelWarn))
+
+		_, ok = file.ParseLevel("loud")
+		Expect(ok).To(BeFalse())
+
+		encoded, _ := json.Marshal(file.LevelError)
+		Expect(string(encoded)).To(Equal(`"error"`))
+	})
+
+	It("composes with the keyword filter", func() {
+		fileName := writeTestFile("ERROR db down\nINFO db up\nWARN db slow\nERROR cache down\nDEBUG db query\n")
+
+		matcher := file.And(file.KeywordMatcher("db"), file.LevelMatcher(file.LevelWarn, file.ClassifyLevel))
+		lines, err := file.ReadLastNLinesMatching(fileName, 10, matcher)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"WARN db slow", "ERROR db down"}))
+
+		paginated, _, err := file.ReadLastNLinesMatchingPagination(fileName, 10, matcher, 0)
+		Expect(err).To(BeNil())
+		Expect(paginated).To(Equal(lines))
+
+		Expect(file.LevelMatcher(file.LevelUnknown, file.ClassifyLevel)).To(Equal(file.MatchAll))
+	})
+
+	It("orders levels in queries", func() {
+		matcher, err := file.NewQueryMatcher("level>=warn", file.FormatAuto)
+		Expect(err).To(BeNil())
+
+		Expect(matcher.Match(`{"level":"error"}`)).To(BeTrue())
+		Expect(matcher.Match(`{"level":"info"}`)).To(BeFalse())
+		Expect(matcher.Match(`2026-10-17 14:02:03 [W] disk almost full`)).To(BeTrue())
+		Expect(matcher.Match(`plain`)).To(BeFalse())
+	})
+})
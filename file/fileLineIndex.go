@@ -0,0 +1,437 @@
+package file
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LineIndexDir is where the line indexes are kept, one file per indexed log file.
+// empty (the default) turns indexing off, SkipLinesOffset then scans the file backwards.
+// set it before serving any requests, like ReadBufferSize
+var LineIndexDir = ""
+
+// LineIndexInterval is K, the index remembers where every Kth line starts.
+// a lookup reads at most K lines
+var LineIndexInterval = 4096
+
+// INDEX_CHECKSUM_SIZE is how many bytes in front of the end of the indexed part of a file are checksummed,
+// a file that has been truncated and written again doesn't have the same bytes there anymore
+const INDEX_CHECKSUM_SIZE = 1 << 12
+
+// indexedPart is the first Size bytes of a file, the part an index covers
+type indexedPart struct {
+	Device   uint64 `json:"device"`
+	Inode    uint64 `json:"inode"`
+	Size     int64  `json:"size"`
+	Checksum uint32 `json:"checksum"`
+}
+
+// validFor reports whether the file is still the one that was indexed: same inode, not truncated,
+// and the same bytes in front of the end of the indexed part
+func (part indexedPart) validFor(file Reader, identity FileIdentity) bool {
+	if !identity.SameFile(FileIdentity{Device: part.Device, Inode: part.Inode}) || part.Size > identity.Size {
+		return false
+	}
+
+	checksum, err := indexChecksum(file, part.Size)
+	return err == nil && checksum == part.Checksum
+}
+
+func indexChecksum(file Reader, size int64) (uint32, error) {
+	start := size - INDEX_CHECKSUM_SIZE
+	if start < 0 {
+		start = 0
+	}
+
+	buf := make([]byte, size-start)
+	if err := readBufferAt(file, buf, start); err != nil {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(buf), nil
+}
+
+// lineIndex knows where every Interval-th line of the first Size bytes of a file starts.
+// lines are counted from the beginning of the file, starting at 0, and end with a line break.
+// Size is always right behind a line break, the lines after it aren't indexed yet
+type lineIndex struct {
+	Interval int `json:"interval"`
+	indexedPart
+	// Lines is the number of lines in the first Size bytes
+	Lines int64 `json:"lines"`
+	// Starts[i] is where line (i+1)*Interval starts
+	Starts []int64 `json:"starts"`
+}
+
+// lineIndexer is LineIndexDir and LineIndexInterval, taken when a read starts
+type lineIndexer struct {
+	dir      string
+	interval int
+}
+
+func currentLineIndexer() lineIndexer {
+	return lineIndexer{dir: LineIndexDir, interval: LineIndexInterval}
+}
+
+// lineIndexes are the indexes of the files read so far, by file name
+var lineIndexes = struct {
+	sync.Mutex
+	indexes  map[string]lineIndex
+	building map[string]bool
+}{indexes: map[string]lineIndex{}, building: map[string]bool{}}
+
+// SkipLinesOffset returns the offset (counted from the end of the file, like LineReturn.Offset)
+// to start reading at to skip the newest skip lines of fileName, for ReadLastNLinesMatchingPagination.
+// with LineIndexDir set, the lines up to where the file has been indexed are looked up in the index
+// and only the lines written since are scanned. the index is brought up to date in the background
+func SkipLinesOffset(fileName string, skip int) (int64, error) {
+	if skip <= 0 {
+		return 0, nil
+	}
+	if isCompressed(fileName) {
+		return skipLinesByReading(fileName, skip)
+	}
+
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return skipLinesOffsetIn(fileName, file, fileSize, skip)
+}
+
+// ReadLastNLinesMatchingSkipping is ReadLastNLinesMatchingPagination starting behind the newest skip lines (see SkipLinesOffset).
+// the lines are skipped and read in the same open file, with its size pinned, lines appended in between don't shift them
+func ReadLastNLinesMatchingSkipping(fileName string, n int, matcher Matcher, skip int) ([]string, error) {
+	// compressed files don't grow
+	if isCompressed(fileName) {
+		offset, err := SkipLinesOffset(fileName, skip)
+		if err != nil {
+			return nil, err
+		}
+		lines, _, err := ReadLastNLinesMatchingPagination(fileName, n, matcher, offset)
+		return lines, err
+	}
+
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	offset := int64(0)
+	if skip > 0 {
+		if offset, err = skipLinesOffsetIn(fileName, file, fileSize, skip); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := NewBackwardLineReader(file, fileSize)
+	if _, err := reader.Seek(-offset, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	lines, _, err := collectBackwardLines(reader, n, matcher, newResultBudget())
+	if err != nil {
+		return nil, err
+	}
+	return lineStrings(lines), nil
+}
+
+// skipLinesOffsetIn is SkipLinesOffset on an already opened file, the offset is counted back from fileSize
+func skipLinesOffsetIn(fileName string, file Reader, fileSize int64, skip int) (int64, error) {
+	index := lineIndex{}
+	if indexer := currentLineIndexer(); indexer.dir != "" {
+		stat, err := file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		index = indexer.current(fileName, file, identityFromFileInfo(stat))
+		if index.Size < fileSize {
+			go indexer.build(fileName)
+		}
+	}
+
+	return index.skipOffset(file, fileSize, skip)
+}
+
+// BuildLineIndex indexes the lines of fileName that aren't indexed yet and saves the index in LineIndexDir.
+// the index is started over if the file has been replaced (its inode changed) or truncated.
+// only one build per file runs at a time, a build already running for fileName makes this a no-op
+func BuildLineIndex(fileName string) error {
+	return currentLineIndexer().build(fileName)
+}
+
+func (indexer lineIndexer) build(fileName string) error {
+	if indexer.dir == "" || isCompressed(fileName) {
+		return nil
+	}
+
+	lineIndexes.Lock()
+	if lineIndexes.building[fileName] {
+		lineIndexes.Unlock()
+		return nil
+	}
+	lineIndexes.building[fileName] = true
+	lineIndexes.Unlock()
+
+	defer func() {
+		lineIndexes.Lock()
+		delete(lineIndexes.building, fileName)
+		lineIndexes.Unlock()
+	}()
+
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	index := indexer.current(fileName, file, identityFromFileInfo(stat))
+	indexedSize := index.Size
+
+	// work on a copy, readers might be using the current one
+	index.Starts = append([]int64{}, index.Starts...)
+	err = scanLineBreaksForward(file, index.Size, fileSize, func(position int64) bool {
+		index.Lines++
+		if index.Lines%int64(index.Interval) == 0 {
+			index.Starts = append(index.Starts, position+1)
+		}
+		index.Size = position + 1
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	// nothing new but maybe a line that's still being written
+	if index.Size == indexedSize {
+		return nil
+	}
+
+	if index.Checksum, err = indexChecksum(file, index.Size); err != nil {
+		return err
+	}
+
+	lineIndexes.Lock()
+	lineIndexes.indexes[fileName] = index
+	lineIndexes.Unlock()
+
+	return indexer.save(fileName, index)
+}
+
+// current returns the index of fileName if it's still valid for the file, an empty one if it isn't.
+// it's loaded from the index dir the first time fileName is asked for
+func (indexer lineIndexer) current(fileName string, file Reader, identity FileIdentity) lineIndex {
+	lineIndexes.Lock()
+	index, found := lineIndexes.indexes[fileName]
+	lineIndexes.Unlock()
+
+	if !found {
+		index = indexer.load(fileName)
+	}
+	if index.Interval != indexer.interval || !index.validFor(file, identity) {
+		return lineIndex{Interval: indexer.interval, indexedPart: indexedPart{Device: identity.Device, Inode: identity.Inode}}
+	}
+
+	if !found {
+		lineIndexes.Lock()
+		lineIndexes.indexes[fileName] = index
+		lineIndexes.Unlock()
+	}
+	return index
+}
+
+// skipOffset finds where the line skip lines back from the end of the file starts.
+// the lines behind the index are counted backwards, then the index takes over
+func (index lineIndex) skipOffset(file Reader, fileSize int64, skip int) (int64, error) {
+	// the line skip lines back starts right behind the (skip+1)th line break from the end
+	seen := 0
+	start := int64(-1)
+	err := scanLineBreaksBackward(file, index.Size, fileSize, func(position int64) bool {
+		seen++
+		if seen == skip+1 {
+			start = position + 1
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if start >= 0 {
+		return fileSize - start, nil
+	}
+
+	// the line we're after is among the indexed ones, or in front of the beginning of the file
+	line := index.Lines + int64(seen) - int64(skip)
+	if line <= 0 {
+		return fileSize, nil
+	}
+
+	start, err = index.lineStart(file, line)
+	if err != nil {
+		return 0, err
+	}
+	return fileSize - start, nil
+}
+
+// lineStart returns where line starts, line has to be one of the indexed lines
+func (index lineIndex) lineStart(file Reader, line int64) (int64, error) {
+	checkpoint := line / int64(index.Interval)
+	checkpointLine := checkpoint * int64(index.Interval)
+	start := int64(0)
+	if checkpoint > 0 {
+		start = index.Starts[checkpoint-1]
+	}
+	if checkpointLine == line {
+		return start, nil
+	}
+
+	// count the lines from the checkpoint on, less than Interval of them
+	seen := int64(0)
+	err := scanLineBreaksForward(file, start, index.Size, func(position int64) bool {
+		seen++
+		if checkpointLine+seen == line {
+			start = position + 1
+			return false
+		}
+		return true
+	})
+	return start, err
+}
+
+// skipLinesByReading is SkipLinesOffset for files that can't be indexed, it reads through the lines to skip
+func skipLinesByReading(fileName string, skip int) (int64, error) {
+	readChunk, release, err := lineChunkReader(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	var offset int64 = 0
+	for skipped := 0; skipped < skip; {
+		lines, err := readChunk(offset)
+		if err != nil {
+			return 0, err
+		}
+		if len(lines) == 0 {
+			break
+		}
+
+		take := skip - skipped
+		if take > len(lines) {
+			take = len(lines)
+		}
+		offset = lines[take-1].Offset
+		skipped += take
+	}
+
+	return offset, nil
+}
+
+// scanLineBreaksForward calls found with the position of each line break between from and to, in order,
+// until found returns false
+func scanLineBreaksForward(file Reader, from int64, to int64, found func(position int64) bool) error {
+	buf := make([]byte, ReadBufferSize)
+	for start := from; start < to; start += int64(len(buf)) {
+		chunk := buf
+		if to-start < int64(len(chunk)) {
+			chunk = chunk[:to-start]
+		}
+		if err := readBufferAt(file, chunk, start); err != nil {
+			return err
+		}
+
+		for i := 0; ; {
+			index := bytes.IndexByte(chunk[i:], '\n')
+			if index == -1 {
+				break
+			}
+			if !found(start + int64(i+index)) {
+				return nil
+			}
+			i += index + 1
+		}
+	}
+
+	return nil
+}
+
+// scanLineBreaksBackward calls found with the position of each line break between from and to, newest first,
+// until found returns false
+func scanLineBreaksBackward(file Reader, from int64, to int64, found func(position int64) bool) error {
+	buf := make([]byte, ReadBufferSize)
+	for end := to; end > from; {
+		start := end - int64(len(buf))
+		if start < from {
+			start = from
+		}
+		chunk := buf[:end-start]
+		if err := readBufferAt(file, chunk, start); err != nil {
+			return err
+		}
+
+		for index := bytes.LastIndexByte(chunk, '\n'); index != -1; index = bytes.LastIndexByte(chunk[:index], '\n') {
+			if !found(start + int64(index)) {
+				return nil
+			}
+		}
+		end = start
+	}
+
+	return nil
+}
+
+// indexPath is where an index of fileName is saved in dir, named after a hash of the path
+// so it doesn't matter where in the roots the file is
+func indexPath(dir string, fileName string, extension string) string {
+	hash := sha256.Sum256([]byte(fileName))
+	return filepath.Join(dir, hex.EncodeToString(hash[:16])+extension)
+}
+
+// load reads the saved index of fileName, an empty one if there is none or it can't be read
+func (indexer lineIndexer) load(fileName string) lineIndex {
+	index := lineIndex{}
+	content, err := os.ReadFile(indexPath(indexer.dir, fileName, ".lineindex"))
+	if err != nil || json.Unmarshal(content, &index) != nil {
+		return lineIndex{}
+	}
+	return index
+}
+
+func (indexer lineIndexer) save(fileName string, index lineIndex) error {
+	content, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return writeIndexFile(indexPath(indexer.dir, fileName, ".lineindex"), content)
+}
+
+// writeIndexFile writes an index through a temp file next to path, so a reader never sees half of it
+func writeIndexFile(path string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
@@ -0,0 +1,120 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Line index", func() {
+	var fileName string
+
+	// skipped reads the 2 lines in front of the newest skip lines
+	skipped := func(skip int) []string {
+		offset, err := file.SkipLinesOffset(fileName, skip)
+		Expect(err).To(BeNil())
+		lines, _, err := file.ReadLastNLinesMatchingPagination(fileName, 2, file.MatchAll, offset)
+		Expect(err).To(BeNil())
+		return lines
+	}
+
+	// offsets are the SkipLinesOffset of every skip, computed with and without the index
+	offsets := func(indexed bool, maxSkip int) []int64 {
+		dir := file.LineIndexDir
+		if !indexed {
+			file.LineIndexDir = ""
+			defer func() { file.LineIndexDir = dir }()
+		}
+
+		result := []int64{}
+		for skip := 0; skip <= maxSkip; skip++ {
+			offset, err := file.SkipLinesOffset(fileName, skip)
+			Expect(err).To(BeNil())
+			result = append(result, offset)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		dir, err := os.MkdirTemp("", "logmonitor-index")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, dir)
+
+		file.LineIndexDir = dir
+		file.LineIndexInterval = 10
+		DeferCleanup(func() {
+			file.LineIndexDir = ""
+			file.LineIndexInterval = 4096
+		})
+
+		fileName = writeTestLines(1000)
+	})
+
+	It("skips lines without an index", func() {
+		file.LineIndexDir = ""
+
+		Expect(skipped(0)).To(Equal([]string{"Line 1000 of the test file", "Line 999 of the test file"}))
+		Expect(skipped(5)).To(Equal([]string{"Line 995 of the test file", "Line 994 of the test file"}))
+		Expect(skipped(998)).To(Equal([]string{"Line 2 of the test file", "Line 1 of the test file"}))
+		Expect(skipped(1000)).To(BeEmpty())
+		Expect(skipped(5000)).To(BeEmpty())
+	})
+
+	It("finds the same lines through the index", func() {
+		Expect(file.BuildLineIndex(fileName)).To(Succeed())
+		saved, err := filepath.Glob(filepath.Join(file.LineIndexDir, "*.lineindex"))
+		Expect(err).To(BeNil())
+		Expect(saved).To(HaveLen(1))
+
+		Expect(offsets(true, 1001)).To(Equal(offsets(false, 1001)))
+		Expect(skipped(995)).To(Equal([]string{"Line 5 of the test file", "Line 4 of the test file"}))
+	})
+
+	It("counts the lines appended since the index was built", func() {
+		Expect(file.BuildLineIndex(fileName)).To(Succeed())
+		for i := 1; i <= 15; i++ {
+			appendToFile(fileName, fmt.Sprintf("Appended %d\n", i))
+		}
+
+		Expect(skipped(14)).To(Equal([]string{"Appended 1", "Line 1000 of the test file"}))
+		Expect(skipped(20)).To(Equal([]string{"Line 995 of the test file", "Line 994 of the test file"}))
+		Expect(offsets(true, 1020)).To(Equal(offsets(false, 1020)))
+	})
+
+	It("skips and reads in one go", func() {
+		Expect(file.BuildLineIndex(fileName)).To(Succeed())
+		appendToFile(fileName, "Appended 1\nAppended 2\n")
+
+		for _, skip := range []int{0, 1, 3, 500, 1002, 2000} {
+			lines, err := file.ReadLastNLinesMatchingSkipping(fileName, 2, file.MatchAll, skip)
+			Expect(err).To(BeNil())
+			Expect(lines).To(Equal(skipped(skip)))
+		}
+	})
+
+	It("starts over when the file is truncated or replaced", func() {
+		Expect(file.BuildLineIndex(fileName)).To(Succeed())
+
+		// same inode, fewer lines
+		Expect(os.WriteFile(fileName, []byte("first\nsecond\nthird\n"), 0644)).To(Succeed())
+		Expect(skipped(1)).To(Equal([]string{"second", "first"}))
+
+		// same inode, more lines than before but different ones
+		content := ""
+		for i := 1; i <= 1200; i++ {
+			content += fmt.Sprintf("Rewritten %d\n", i)
+		}
+		Expect(os.WriteFile(fileName, []byte(content), 0644)).To(Succeed())
+		Expect(file.BuildLineIndex(fileName)).To(Succeed())
+		Expect(skipped(1100)).To(Equal([]string{"Rewritten 100", "Rewritten 99"}))
+
+		// another file moved in its place
+		replacement := filepath.Join(filepath.Dir(fileName), "replacement.log")
+		Expect(os.WriteFile(replacement, []byte("one\ntwo\nthree\nfour\n"), 0644)).To(Succeed())
+		Expect(os.Rename(replacement, fileName)).To(Succeed())
+		Expect(skipped(2)).To(Equal([]string{"two", "one"}))
+	})
+})
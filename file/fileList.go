@@ -0,0 +1,156 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileInfo describes a log file for clients to pick from
+type FileInfo struct {
+	// Name is the path relative to Root, the filename to pass to the other endpoints
+	Name        string      `json:"name"`
+	Root        string      `json:"root"`
+	Size        int64       `json:"size"`
+	ModTime     time.Time   `json:"mtime"`
+	Inode       uint64      `json:"inode"`
+	Compression Compression `json:"compression"`
+	Format      Format      `json:"format"`
+	// EstimatedLines is exact for small files and extrapolated from the start of the file for the rest
+	EstimatedLines int64 `json:"estimated_lines"`
+}
+
+// ListOptions selects a page of the files ListFiles returns
+type ListOptions struct {
+	// Name filters the files by name, a glob pattern (nginx/*.log) or a case insensitive substring
+	Name   string
+	Offset int
+	Limit  int
+}
+
+// EXACT_LINE_COUNT_SIZE is the size up to which ListFiles counts the lines of a plain file instead of estimating
+const EXACT_LINE_COUNT_SIZE = 1 << 20
+
+// ListFiles returns the readable files under roots, sorted by root and name, along with the number of files
+// before paging. roots and directories the server can't read are skipped.
+// compression, format and line count are only looked up for the files in the page
+func (roots Roots) ListFiles(options ListOptions) ([]FileInfo, int, error) {
+	files := []FileInfo{}
+	for _, root := range roots {
+		start := len(files)
+		rootPath, err := filepath.EvalSymlinks(root.Path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// same as Resolve, a root that doesn't exist (yet) has no files
+			continue
+		}
+		if err != nil {
+			return nil, 0, translateOpenError(root.Path, err)
+		}
+
+		err = filepath.WalkDir(rootPath, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrPermission) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+
+			relative, _ := filepath.Rel(rootPath, path)
+			if !matchesName(options.Name, relative) {
+				return nil
+			}
+
+			// symlinks have to stay inside the root, the same as for reading
+			resolved, err := root.resolve(relative)
+			if err != nil {
+				return nil
+			}
+			stat, err := os.Stat(resolved)
+			if err != nil || !stat.Mode().IsRegular() {
+				return nil
+			}
+
+			files = append(files, FileInfo{
+				Name:    filepath.ToSlash(relative),
+				Root:    root.Path,
+				Size:    stat.Size(),
+				ModTime: stat.ModTime(),
+				Inode:   identityFromFileInfo(stat).Inode,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		rootFiles := files[start:]
+		sort.Slice(rootFiles, func(i, j int) bool {
+			return rootFiles[i].Name < rootFiles[j].Name
+		})
+	}
+
+	total := len(files)
+	if options.Offset > len(files) {
+		options.Offset = len(files)
+	}
+	files = files[options.Offset:]
+	if options.Limit > 0 && options.Limit < len(files) {
+		files = files[:options.Limit]
+	}
+
+	for i := range files {
+		files[i].describe(filepath.Join(files[i].Root, files[i].Name))
+	}
+
+	return files, total, nil
+}
+
+// describe fills in compression, format and line count from a sample of the file
+// files that can't be read (permissions...) are listed without them
+func (info *FileInfo) describe(path string) {
+	info.Compression = CompressionNone
+	info.Format = FormatPlain
+
+	sample, err := sampleFile(path)
+	if err != nil {
+		return
+	}
+
+	info.Compression = sample.compression
+	info.Format = DetectFormat(sample.lines())
+
+	lines := int64(bytes.Count(sample.content, []byte{'\n'}))
+	switch {
+	case sample.complete:
+		info.EstimatedLines = lines
+	case sample.compression == CompressionNone && info.Size <= EXACT_LINE_COUNT_SIZE:
+		if file, err := os.Open(path); err == nil {
+			count, _ := LineCounter(file)
+			file.Close()
+			info.EstimatedLines = int64(count)
+		}
+	case sample.consumed > 0:
+		info.EstimatedLines = lines * info.Size / sample.consumed
+	}
+}
+
+func matchesName(filter string, relative string) bool {
+	if filter == "" {
+		return true
+	}
+
+	relative = filepath.ToSlash(relative)
+	if strings.ContainsAny(filter, "*?[") {
+		return matchesAny([]string{filter}, relative)
+	}
+
+	return strings.Contains(strings.ToLower(relative), strings.ToLower(filter))
+}
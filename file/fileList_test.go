@@ -0,0 +1,108 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Formats", func() {
+	It("detects the format of single lines", func() {
+		Expect(file.DetectLineFormat(`{"level":"info","msg":"started"}`)).To(Equal(file.FormatJSON))
+		Expect(file.DetectLineFormat(`level=info msg="server started" port=8080`)).To(Equal(file.FormatLogfmt))
+		Expect(file.DetectLineFormat(`Oct 11 22:14:15 mymachine su: 'su root' failed`)).To(Equal(file.FormatSyslog))
+		Expect(file.DetectLineFormat(`<34>1 2003-10-11T22:14:15.003Z mymachine su - ID47 - failed`)).To(Equal(file.FormatSyslog))
+		Expect(file.DetectLineFormat(`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /a.gif HTTP/1.0" 200 2326`)).To(Equal(file.FormatCLF))
+		Expect(file.DetectLineFormat(`Line 1 of the test file`)).To(Equal(file.FormatPlain))
+		Expect(file.DetectLineFormat(`a=b`)).To(Equal(file.FormatPlain))
+	})
+
+	It("goes with the majority of the lines", func() {
+		Expect(file.DetectFormat([]string{`{"a":1}`, `{"a":2}`, `stack trace`})).To(Equal(file.FormatJSON))
+		Expect(file.DetectFormat([]string{`{"a":1}`, `plain`, `stack trace`})).To(Equal(file.FormatPlain))
+		Expect(file.DetectFormat([]string{})).To(Equal(file.FormatPlain))
+	})
+
+	It("detects the format of compressed files", func() {
+		fileName := filepath.Join(filepath.Dir(writeTestFile("")), "app.log.1.gz")
+		writeGzipFile(fileName, "{\"a\":1}\n{\"a\":2}\n")
+
+		format, err := file.DetectFileFormat(fileName)
+		Expect(err).To(BeNil())
+		Expect(format).To(Equal(file.FormatJSON))
+	})
+})
+
+var _ = Describe("ListFiles", func() {
+	var root string
+
+	BeforeEach(func() {
+		var err error
+		root, err = os.MkdirTemp("", "logmonitor-list")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, root)
+
+		Expect(os.MkdirAll(filepath.Join(root, "nginx"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "syslog"), []byte("Oct 11 22:14:15 host su: failed\nOct 11 22:14:16 host su: ok\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "nginx/access.log"), []byte("level=info msg=a\nlevel=info msg=b\nlevel=warn msg=c\n"), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(root, "secure"), []byte("secret\n"), 0644)).To(Succeed())
+		writeGzipFile(filepath.Join(root, "syslog.1.gz"), "old 1\nold 2\n")
+	})
+
+	It("lists the allowed files with their metadata", func() {
+		roots := file.Roots{{Path: root, Deny: []string{"secure"}}}
+
+		files, total, err := roots.ListFiles(file.ListOptions{})
+		Expect(err).To(BeNil())
+		Expect(total).To(Equal(3))
+
+		names := []string{}
+		for _, info := range files {
+			names = append(names, info.Name)
+		}
+		Expect(names).To(Equal([]string{"nginx/access.log", "syslog", "syslog.1.gz"}))
+
+		Expect(files[0].Format).To(Equal(file.FormatLogfmt))
+		Expect(files[0].Compression).To(Equal(file.CompressionNone))
+		Expect(files[0].EstimatedLines).To(Equal(int64(3)))
+		Expect(files[0].Inode).NotTo(BeZero())
+		Expect(files[1].Format).To(Equal(file.FormatSyslog))
+		Expect(files[2].Compression).To(Equal(file.CompressionGzip))
+		Expect(files[2].EstimatedLines).To(Equal(int64(2)))
+	})
+
+	It("filters and pages", func() {
+		roots := file.Roots{{Path: root}}
+
+		files, total, err := roots.ListFiles(file.ListOptions{Name: "SYSLOG"})
+		Expect(err).To(BeNil())
+		Expect(total).To(Equal(2))
+		Expect(files).To(HaveLen(2))
+
+		files, total, err = roots.ListFiles(file.ListOptions{Name: "*.log"})
+		Expect(err).To(BeNil())
+		Expect(total).To(Equal(1))
+		Expect(files[0].Name).To(Equal("nginx/access.log"))
+
+		files, total, err = roots.ListFiles(file.ListOptions{Offset: 1, Limit: 2})
+		Expect(err).To(BeNil())
+		Expect(total).To(Equal(4))
+		Expect(files).To(HaveLen(2))
+		Expect(files[0].Name).To(Equal("secure"))
+
+		files, _, err = roots.ListFiles(file.ListOptions{Offset: 10})
+		Expect(err).To(BeNil())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("estimates the line count of large files", func() {
+		fileName := writeTestLines(100000)
+
+		files, _, err := file.Roots{{Path: filepath.Dir(fileName)}}.ListFiles(file.ListOptions{})
+		Expect(err).To(BeNil())
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].EstimatedLines).To(BeNumerically("~", 100000, 10000))
+	})
+})
@@ -0,0 +1,198 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Matcher decides which log lines the readers return
+// Match is called from several goroutines at once by the parallel reader
+type Matcher interface {
+	Match(line string) bool
+}
+
+// MatcherFunc turns a plain function into a Matcher
+type MatcherFunc func(line string) bool
+
+func (f MatcherFunc) Match(line string) bool {
+	return f(line)
+}
+
+// matchAll is returned for an empty query, readers skip filtering altogether when they see it
+type matchAll struct{}
+
+func (matchAll) Match(string) bool { return true }
+
+// MatchAll matches every line
+var MatchAll Matcher = matchAll{}
+
+func isMatchAll(matcher Matcher) bool {
+	_, ok := matcher.(matchAll)
+	return matcher == nil || ok
+}
+
+// keywordMatcher is a keyword the lines have to contain, it's a type of its own
+// so the keyword index can tell which chunks can't have it (see keywordIndex.candidates)
+type keywordMatcher struct {
+	keyword string
+	// the keyword is lower case and the lines are lowered before looking for it
+	ignoreCase bool
+}
+
+func (matcher keywordMatcher) Match(line string) bool {
+	if matcher.ignoreCase {
+		line = strings.ToLower(line)
+	}
+	return strings.Contains(line, matcher.keyword)
+}
+
+// KeywordMatcher matches lines that contain keyword, an empty keyword matches every line
+func KeywordMatcher(keyword string) Matcher {
+	if keyword == "" {
+		return MatchAll
+	}
+
+	return keywordMatcher{keyword: keyword}
+}
+
+// CaseInsensitiveKeywordMatcher matches lines that contain keyword in any case
+func CaseInsensitiveKeywordMatcher(keyword string) Matcher {
+	if keyword == "" {
+		return MatchAll
+	}
+
+	return keywordMatcher{keyword: strings.ToLower(keyword), ignoreCase: true}
+}
+
+// RegexMatcher matches lines that match the regular expression re
+func RegexMatcher(re *regexp.Regexp) Matcher {
+	return MatcherFunc(re.MatchString)
+}
+
+// Not inverts matcher
+func Not(matcher Matcher) Matcher {
+	return MatcherFunc(func(line string) bool {
+		return !matcher.Match(line)
+	})
+}
+
+// And matches lines that all of matchers match
+func And(matchers ...Matcher) Matcher {
+	matchers = withoutMatchAll(matchers)
+	if len(matchers) == 0 {
+		return MatchAll
+	}
+	if len(matchers) == 1 {
+		return matchers[0]
+	}
+
+	return andMatcher(matchers)
+}
+
+type andMatcher []Matcher
+
+func (matchers andMatcher) Match(line string) bool {
+	for _, matcher := range matchers {
+		if !matcher.Match(line) {
+			return false
+		}
+	}
+	return true
+}
+
+// Or matches lines that any of matchers match
+func Or(matchers ...Matcher) Matcher {
+	for _, matcher := range matchers {
+		if isMatchAll(matcher) {
+			return MatchAll
+		}
+	}
+	if len(matchers) == 0 {
+		return MatchAll
+	}
+	if len(matchers) == 1 {
+		return matchers[0]
+	}
+
+	return orMatcher(matchers)
+}
+
+type orMatcher []Matcher
+
+func (matchers orMatcher) Match(line string) bool {
+	for _, matcher := range matchers {
+		if matcher.Match(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func withoutMatchAll(matchers []Matcher) []Matcher {
+	filtered := []Matcher{}
+	for _, matcher := range matchers {
+		if !isMatchAll(matcher) {
+			filtered = append(filtered, matcher)
+		}
+	}
+	return filtered
+}
+
+// MatcherOptions describes a filter the way the api receives it
+type MatcherOptions struct {
+	// Keywords are matched as substrings, a keyword starting with - matches the lines that don't contain it
+	Keywords []string
+	// Regexes are regular expressions (RE2 syntax) lines need to match
+	Regexes []string
+	// CaseInsensitive applies to both Keywords and Regexes
+	CaseInsensitive bool
+	// Any combines the terms with OR instead of AND
+	Any bool
+}
+
+// NewMatcher builds a single Matcher out of options
+func NewMatcher(options MatcherOptions) (Matcher, error) {
+	terms := []Matcher{}
+	for _, keyword := range options.Keywords {
+		inverted := len(keyword) > 1 && keyword[0] == '-'
+		if inverted {
+			keyword = keyword[1:]
+		}
+		if keyword == "" {
+			continue
+		}
+
+		term := KeywordMatcher(keyword)
+		if options.CaseInsensitive {
+			term = CaseInsensitiveKeywordMatcher(keyword)
+		}
+		if inverted {
+			term = Not(term)
+		}
+		terms = append(terms, term)
+	}
+
+	for _, expression := range options.Regexes {
+		if expression == "" {
+			continue
+		}
+		if options.CaseInsensitive {
+			expression = "(?i)" + expression
+		}
+
+		re, err := regexp.Compile(expression)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		terms = append(terms, RegexMatcher(re))
+	}
+
+	if options.Any {
+		return Or(terms...), nil
+	}
+	return And(terms...), nil
+}
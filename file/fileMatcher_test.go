@@ -0,0 +1,75 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NewMatcher", func() {
+	matches := func(options file.MatcherOptions, lines ...string) []string {
+		matcher, err := file.NewMatcher(options)
+		Expect(err).To(BeNil())
+
+		matched := []string{}
+		for _, line := range lines {
+			if matcher.Match(line) {
+				matched = append(matched, line)
+			}
+		}
+		return matched
+	}
+	lines := []string{"GET /api 200", "GET /api 500 timeout", "POST /login 503", "post /logout 200"}
+
+	It("matches every line without terms", func() {
+		Expect(matches(file.MatcherOptions{}, lines...)).To(Equal(lines))
+		Expect(matches(file.MatcherOptions{Keywords: []string{""}, Any: true}, lines...)).To(Equal(lines))
+	})
+
+	It("combines keywords with AND and inverts keywords starting with -", func() {
+		Expect(matches(file.MatcherOptions{Keywords: []string{"GET", "-timeout"}}, lines...)).
+			To(Equal([]string{"GET /api 200"}))
+	})
+
+	It("combines terms with OR", func() {
+		Expect(matches(file.MatcherOptions{Keywords: []string{"login", "timeout"}, Any: true}, lines...)).
+			To(Equal([]string{"GET /api 500 timeout", "POST /login 503"}))
+	})
+
+	It("matches regular expressions", func() {
+		Expect(matches(file.MatcherOptions{Regexes: []string{` 5\d\d`}}, lines...)).
+			To(Equal([]string{"GET /api 500 timeout", "POST /login 503"}))
+	})
+
+	It("matches case insensitive", func() {
+		Expect(matches(file.MatcherOptions{Keywords: []string{"post"}, CaseInsensitive: true}, lines...)).
+			To(Equal([]string{"POST /login 503", "post /logout 200"}))
+		Expect(matches(file.MatcherOptions{Regexes: []string{"^post"}, CaseInsensitive: true}, lines...)).
+			To(Equal([]string{"POST /login 503", "post /logout 200"}))
+	})
+
+	It("rejects invalid regular expressions", func() {
+		_, err := file.NewMatcher(file.MatcherOptions{Regexes: []string{"("}})
+		Expect(err).To(MatchError(file.ErrInvalidQuery))
+	})
+})
+
+var _ = Describe("ReadLastNLinesMatching", func() {
+	It("filters the same way on all read paths", func() {
+		fileName := writeTestLines(300)
+		matcher, _ := file.NewMatcher(file.MatcherOptions{Regexes: []string{`^Line \d*7 `}, Keywords: []string{"-Line 7 "}})
+
+		lines, err := file.ReadLastNLinesMatching(fileName, 3, matcher)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"Line 297 of the test file", "Line 287 of the test file", "Line 277 of the test file"}))
+
+		paginated, _, err := file.ReadLastNLinesMatchingPagination(fileName, 3, matcher, 0)
+		Expect(err).To(BeNil())
+		Expect(paginated).To(Equal(lines))
+
+		all, err := file.ReadLastNLinesMatchingP(fileName, 100, matcher)
+		Expect(err).To(BeNil())
+		Expect(all).To(HaveLen(29))
+		Expect(string(all[28])).To(Equal("Line 17 of the test file\n"))
+	})
+})
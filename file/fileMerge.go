@@ -0,0 +1,186 @@
+package file
+
+import (
+	"container/heap"
+	"errors"
+	"time"
+)
+
+// MergedLine is a line returned by ReadLastNLinesMerged
+// Source is the file the line comes from and Offset its offset in there (same as LineReturn.Offset)
+type MergedLine struct {
+	Line      string    `json:"line"`
+	Source    string    `json:"source"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ReadLastNLinesMerged returns the last n lines that matcher matches across all of fileNames, newest first.
+// the lines of the files are interleaved by the timestamps extractTimestamp finds in them.
+// a line without timestamp (e.g. a line of a stack trace) gets the timestamp of the closest line above it that has one,
+// so it stays right behind that line. at most n of them (and the result budget) wait for that line,
+// the ones after take the timestamp of the line below them, zero if there is none.
+// files that are gone or can't be read are skipped, unless none of them can be read
+func ReadLastNLinesMerged(fileNames []string, n int, matcher Matcher, extractTimestamp TimestampExtractor) ([]MergedLine, error) {
+	sources := &mergeHeap{}
+	// the error of the last file skipped, and how many have been opened
+	var skipped error
+	opened := 0
+	for i, fileName := range fileNames {
+		readChunk, release, err := lineChunkReader(fileName)
+		if errors.Is(err, ErrFileNotFound) || errors.Is(err, ErrPermissionDenied) {
+			skipped = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		opened++
+		// the files stay open until the merge is done
+		defer release()
+
+		source := &mergeSource{
+			fileName:         fileName,
+			index:            i,
+			readChunk:        readChunk,
+			extractTimestamp: extractTimestamp,
+			limit:            n,
+		}
+		if err := source.fill(); err != nil {
+			return nil, err
+		}
+		if len(source.pending) > 0 {
+			*sources = append(*sources, source)
+		}
+	}
+	if opened == 0 && skipped != nil {
+		return nil, skipped
+	}
+	heap.Init(sources)
+
+	budget := newResultBudget()
+	lines := []MergedLine{}
+	for len(lines) < n && sources.Len() > 0 {
+		source := (*sources)[0]
+		line := source.pending[0]
+		source.pending = source.pending[1:]
+
+		if isMatchAll(matcher) || matcher.Match(line.Line) {
+			if !budget.take(len(line.Line)) {
+				break
+			}
+			lines = append(lines, line)
+		}
+
+		if err := source.fill(); err != nil {
+			return nil, err
+		}
+		if len(source.pending) == 0 {
+			heap.Pop(sources)
+		} else {
+			heap.Fix(sources, 0)
+		}
+	}
+
+	return lines, nil
+}
+
+// mergeSource reads one of the merged files backwards
+type mergeSource struct {
+	fileName         string
+	index            int
+	readChunk        func(fileOffset int64) ([]LineReturn, error)
+	extractTimestamp TimestampExtractor
+	// limit is the most lines the merge hands out, fill holds back no more lines without timestamp than that
+	limit int
+
+	// lines read from the file but not handed out yet, newest first
+	buffered []LineReturn
+	offset   int64
+	done     bool
+	// lines with known timestamps, newest first. pending[0] is the next line to hand out
+	pending []MergedLine
+	// the timestamp of the last line that went into pending
+	lastTimestamp time.Time
+}
+
+// fill makes sure there is a line in pending unless the whole file has been read.
+// lines without timestamp are held back until we've seen the line they belong to,
+// up to limit lines and the result budget. past that they get the timestamp of the line below them
+func (source *mergeSource) fill() error {
+	withoutTimestamp := []LineReturn{}
+	waiting := newResultBudget()
+	for len(source.pending) == 0 {
+		if len(source.buffered) == 0 {
+			if source.done {
+				break
+			}
+
+			lines, err := source.readChunk(source.offset)
+			if err != nil {
+				return err
+			}
+			if len(lines) == 0 {
+				source.done = true
+				continue
+			}
+			source.buffered = lines
+			source.offset = lines[len(lines)-1].Offset
+		}
+
+		line := source.buffered[0]
+		source.buffered = source.buffered[1:]
+
+		timestamp, ok := source.extractTimestamp(line.Line)
+		if !ok {
+			if len(withoutTimestamp) < source.limit && waiting.take(len(line.Line)) {
+				withoutTimestamp = append(withoutTimestamp, line)
+				continue
+			}
+			// a file without timestamps would be read to the start
+			timestamp = source.lastTimestamp
+		}
+
+		for _, l := range append(withoutTimestamp, line) {
+			source.pending = append(source.pending, source.mergedLine(l, timestamp))
+		}
+		source.lastTimestamp = timestamp
+	}
+
+	// the lines at the beginning of the file have nothing above them to take the timestamp from
+	if len(source.pending) == 0 {
+		for _, l := range withoutTimestamp {
+			source.pending = append(source.pending, source.mergedLine(l, time.Time{}))
+		}
+	}
+
+	return nil
+}
+
+func (source *mergeSource) mergedLine(line LineReturn, timestamp time.Time) MergedLine {
+	return MergedLine{Line: line.Line, Source: source.fileName, Offset: line.Offset, Timestamp: timestamp}
+}
+
+// mergeHeap orders the sources by the timestamp of their next line, newest first
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	ti, tj := h[i].pending[0].Timestamp, h[j].pending[0].Timestamp
+	if ti.Equal(tj) {
+		return h[i].index < h[j].index
+	}
+	return ti.After(tj)
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x any) { *h = append(*h, x.(*mergeSource)) }
+
+func (h *mergeHeap) Pop() any {
+	old := *h
+	source := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return source
+}
@@ -0,0 +1,88 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("ExtractTimestamp", func() {
+	It("finds RFC3339 timestamps", func() {
+		timestamp, ok := file.ExtractTimestamp("2026-10-17T14:02:03.5Z GET /api")
+		Expect(ok).To(BeTrue())
+		Expect(timestamp).To(Equal(time.Date(2026, 10, 17, 14, 2, 3, 500000000, time.UTC)))
+	})
+
+	It("finds the timestamps WriteFileHelper writes", func() {
+		now := time.Now()
+		timestamp, ok := file.ExtractTimestamp(now.String())
+		Expect(ok).To(BeTrue())
+		Expect(timestamp.Equal(now.Round(0))).To(BeTrue())
+	})
+
+	It("ignores lines without timestamp", func() {
+		_, ok := file.ExtractTimestamp("    at com.example.Main(Main.java:10)")
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("ReadLastNLinesMerged", func() {
+	It("interleaves the lines of several files by timestamp", func() {
+		app := writeTestFile("2026-10-17T14:00:01Z app start\n" +
+			"2026-10-17T14:00:04Z app ERROR boom\n" +
+			"  at Main.java:10\n" +
+			"2026-10-17T14:00:06Z app done\n")
+		nginx := writeTestFile("2026-10-17T14:00:02Z nginx GET /\n" +
+			"2026-10-17T14:00:05Z nginx GET /api\n")
+
+		lines, err := file.ReadLastNLinesMerged([]string{app, nginx}, 10, file.MatchAll, file.ExtractTimestamp)
+		Expect(err).To(BeNil())
+
+		merged := []string{}
+		for _, line := range lines {
+			merged = append(merged, line.Line)
+		}
+		Expect(merged).To(Equal([]string{
+			"2026-10-17T14:00:06Z app done",
+			"2026-10-17T14:00:05Z nginx GET /api",
+			"  at Main.java:10",
+			"2026-10-17T14:00:04Z app ERROR boom",
+			"2026-10-17T14:00:02Z nginx GET /",
+			"2026-10-17T14:00:01Z app start",
+		}))
+		Expect(lines[1].Source).To(Equal(nginx))
+		Expect(lines[2].Source).To(Equal(app))
+		Expect(lines[2].Timestamp).To(Equal(time.Date(2026, 10, 17, 14, 0, 4, 0, time.UTC)))
+	})
+
+	It("filters and limits the merged lines", func() {
+		first := writeTestFile("2026-10-17T14:00:01Z a GET\n2026-10-17T14:00:03Z a POST\n")
+		second := writeTestFile("2026-10-17T14:00:02Z b GET\n2026-10-17T14:00:04Z b GET\n")
+
+		lines, err := file.ReadLastNLinesMerged([]string{first, second}, 2, file.KeywordMatcher("GET"), file.ExtractTimestamp)
+		Expect(err).To(BeNil())
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0].Line).To(Equal("2026-10-17T14:00:04Z b GET"))
+		Expect(lines[1].Line).To(Equal("2026-10-17T14:00:02Z b GET"))
+	})
+	It("doesn't read a file without timestamps to the start", func() {
+		lines, err := file.ReadLastNLinesMerged([]string{writeTestLines(1000)}, 2, file.MatchAll, file.ExtractTimestamp)
+		Expect(err).To(BeNil())
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0].Line).To(Equal("Line 1000 of the test file"))
+		Expect(lines[1].Line).To(Equal("Line 999 of the test file"))
+		Expect(lines[1].Timestamp.IsZero()).To(BeTrue())
+	})
+	It("skips files that are gone, unless all of them are", func() {
+		app := writeTestFile("2026-10-17T14:00:01Z app start\n")
+
+		lines, err := file.ReadLastNLinesMerged([]string{app + ".gone", app}, 10, file.MatchAll, file.ExtractTimestamp)
+		Expect(err).To(BeNil())
+		Expect(lines).To(HaveLen(1))
+		Expect(lines[0].Source).To(Equal(app))
+
+		_, err = file.ReadLastNLinesMerged([]string{app + ".gone"}, 10, file.MatchAll, file.ExtractTimestamp)
+		Expect(err).To(MatchError(file.ErrFileNotFound))
+	})
+})
@@ -1,10 +1,6 @@
 package file
 
-import (
-	"bytes"
-	"os"
-	"strings"
-)
+import "io"
 
 type LineReturn struct {
 	Line   string
@@ -14,134 +10,140 @@ type LineReturn struct {
 // ReadLastLinesWithOffsetPagination reads the last initBufSize bytes in front of the fileOffset bytes before EOF
 // fileOffset needs to be at a line break. otherwise the incomplete line at the end of the buffer will be lost
 // returns the complete lines in reverse order and their individual line's file offset and an error if any
-// Note: the initBufSize needs to be longer than the maximum length of a log line
+// Note: if a log line is longer than initBufSize, the buffer grows until the line fits (see readLastLines)
 func ReadLastLinesWithOffsetPagination(fileName string, fileOffset int64, initBufSize int) ([]LineReturn, error) {
-	file, err := os.Open(fileName)
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
+	lines, _, err := readLastLines(fileName, fileOffset, initBufSize)
+	return lines, err
+}
 
-	stat, err := os.Stat(fileName)
-	if err != nil {
-		panic(err)
-	}
+// ReadLastNLinesWithKeywordPagination reads the file backwards (see BackwardLineReader), starting offset bytes before EOF,
+// until we reach the target lines of log.
+// if input query is not empty, log lines are filtered first before they are appended
+// for compressed files the offset is counted from the end of the decompressed content
+func ReadLastNLinesWithKeywordPagination(fileName string, n int, query string, offset int64) ([]string, int64, error) {
+	return ReadLastNLinesMatchingPagination(fileName, n, KeywordMatcher(query), offset)
+}
 
-	fileSize := stat.Size()
-	// the fileOffset has exceeded the size of the file,
-	// i.e., we've scanned through the whole file
-	if fileSize <= fileOffset {
-		return []LineReturn{}, nil
-	}
+// ReadLastNLinesMatchingPagination is ReadLastNLinesWithKeywordPagination for any Matcher
+func ReadLastNLinesMatchingPagination(fileName string, n int, matcher Matcher, offset int64) ([]string, int64, error) {
+	return readLastNLinesMatchingPagination(fileName, n, matcher, offset, ReadBufferSize)
+}
 
-	curBufStart := fileSize - fileOffset - int64(initBufSize)
-	bufSize := initBufSize
+// ReadLastNLinesWithKeywordPaginationInternal exposes buffer size for testing only
+// internal only!!!
+func ReadLastNLinesWithKeywordPaginationInternal(
+	fileName string, n int, query string, offset int64, initBufSize int) ([]string, int64, error) {
+	return readLastNLinesMatchingPagination(fileName, n, KeywordMatcher(query), offset, initBufSize)
+}
 
-	if fileSize-fileOffset < int64(initBufSize) {
-		// the remainder of the file is not big enough for a full buffer size
-		curBufStart = 0
-		bufSize = int(fileSize - fileOffset)
+func readLastNLinesMatchingPagination(
+	fileName string, n int, matcher Matcher, offset int64, initBufSize int) ([]string, int64, error) {
+	if isCompressed(fileName) {
+		lines, nextOffset, _, err := collectLastLinesCompressed(fileName, n, matcher, offset, newResultBudget())
+		if err != nil {
+			return nil, 0, err
+		}
+		return lineStrings(lines), nextOffset, nil
 	}
 
-	buf := make([]byte, bufSize)
-	// ReadAt can't start from the very beginning of a file
-	if curBufStart == 0 {
-		_, err = file.Read(buf)
-	} else {
-		_, err = file.ReadAt(buf, curBufStart)
-	}
+	reader, err := OpenBackwardLineReader(fileName)
 	if err != nil {
-		panic(err)
-	}
-
-	// validate that the input fileOffset value is correct
-	if buf[len(buf)-1] != '\n' {
-		panic("the last byte in the buffer is not a line break")
-	}
-
-	// find all the line break's locations (their index within the buffer)
-	offset := 0
-	indices := []int64{}
-	for {
-		index := bytes.IndexByte(buf[offset:], '\n')
-		// no more line breaks
-		if index == -1 {
-			break
-		}
-
-		indices = append(indices, int64(index+offset))
-		offset += index + 1
+		return nil, 0, err
 	}
+	defer reader.Close()
 
-	lastLines := []LineReturn{}
-	for i := 0; i < len(indices)-1; i++ {
-		// between two adjacent line breaks is a complete line
-		endLineBreakIndex := indices[len(indices)-1-i]
-		startLineBreakIndex := indices[len(indices)-2-i]
-
-		lastLines = append(lastLines, LineReturn{
-			Line:   string(buf[startLineBreakIndex+1 : endLineBreakIndex]),
-			Offset: int64(bufSize) - startLineBreakIndex - 1 + fileOffset,
-		})
+	reader.BufferSize = initBufSize
+	if _, err := reader.Seek(-offset, io.SeekEnd); err != nil {
+		return nil, 0, err
 	}
-
-	// if this is the beginning of the file, append the first line
-	// which starts at the beginning of the buffer and ends at the first line break
-	if curBufStart == 0 {
-		lastLines = append(lastLines, LineReturn{
-			Line:   string(buf[:indices[0]]),
-			Offset: fileSize,
-		})
-		// set fileOffset to be file size, indicating we've scanned through
-		return lastLines, nil
+	lines, nextOffset, err := collectBackwardLines(reader, n, matcher, newResultBudget())
+	if err != nil {
+		return nil, 0, err
 	}
 
-	// the new offset indicates the first line break's location from the end of the file
-	// which will be the end location of next round of buffer reading
-	return lastLines, nil
+	return lineStrings(lines), nextOffset, nil
 }
 
-// ReadLastNLinesWithKeywordPagination keeps calling ReadLastLinesWithOffsetPagination
-// until we reach the target lines of log.
-// if input query is not empty, log lines are filtered first before they are appended
-func ReadLastNLinesWithKeywordPagination(fileName string, n int, query string, offset int64) ([]string, int64, error) {
-	return ReadLastNLinesWithKeywordPaginationInternal(fileName, n, query, offset, READ_BUFFER_SIZE)
-}
-
-// ReadLastNLinesWithKeywordPaginationInternal exposes buffer size for testing only
-// internal only!!!
-func ReadLastNLinesWithKeywordPaginationInternal(
-	fileName string, n int, query string, offset int64, initBufSize int) ([]string, int64, error) {
+// collectLastLines keeps calling readChunk, starting at offset, until we reach n lines that matcher matches
+// or the lines don't fit into budget anymore.
+// returns the lines and the offset the next read should start from
+func collectLastLines(readChunk func(fileOffset int64) ([]LineReturn, error),
+	n int, matcher Matcher, offset int64, budget *resultBudget) ([]LineReturn, int64, error) {
 	lines := []LineReturn{}
 	newlines := []LineReturn{{"", offset}}
+	// scannedOffset is how far from the end of the file we've read so far
+	scannedOffset := offset
 	var err error
 	for len(lines) < n && len(newlines) != 0 {
-		newlines, err = ReadLastLinesWithOffsetPagination(fileName, newlines[len(newlines)-1].Offset, initBufSize)
+		newlines, err = readChunk(scannedOffset)
 		if err != nil {
-			panic(err)
+			return nil, 0, err
+		}
+
+		if len(newlines) != 0 {
+			scannedOffset = newlines[len(newlines)-1].Offset
 		}
 
-		if query == "" {
-			lines = append(lines, newlines...)
-		} else {
-			for _, newline := range newlines {
-				if strings.Contains(newline.Line, query) {
-					lines = append(lines, newline)
+		for _, newline := range newlines {
+			if len(lines) == n {
+				break
+			}
+			if !isMatchAll(matcher) && !matcher.Match(newline.Line) {
+				continue
+			}
+			if !budget.take(len(newline.Line)) {
+				// the next read starts behind the last line that fit
+				if len(lines) == 0 {
+					return lines, offset, nil
 				}
+				return lines, lines[len(lines)-1].Offset, nil
 			}
+			lines = append(lines, newline)
 		}
 	}
 
-	returnSize := n
-	if len(lines) < n {
-		returnSize = len(lines)
+	// we've scanned through the whole file without finding n lines
+	// the next read should start where the scan stopped, not at the last matched line
+	if n <= 0 || len(lines) < n {
+		return lines, scannedOffset, nil
 	}
 
-	returnVal := []string{}
+	return lines, lines[n-1].Offset, nil
+}
+
+// lineChunkReader returns a function reading the lines in front of a given offset, newest first,
+// for callers that walk the whole file backwards chunk by chunk.
+// the file is opened once and its size pinned, offsets stay the same while the file grows.
+// compressed files are read from their decompressed copy (see spillDecompressed) the same way.
+// it returns no lines once the beginning of the file is reached.
+// release needs to be called once the reader is no longer used
+func lineChunkReader(fileName string) (readChunk func(fileOffset int64) ([]LineReturn, error), release func(), err error) {
+	var file Reader
+	if isCompressed(fileName) {
+		file, release, err = spillDecompressed(fileName)
+	} else {
+		file, _, err = openReader(fileName)
+		release = func() { file.Close() }
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := NewBackwardLineReader(file, file.Size())
+	readChunk = func(fileOffset int64) ([]LineReturn, error) {
+		if _, err := reader.Seek(-fileOffset, io.SeekEnd); err != nil {
+			return nil, err
+		}
+		return reader.nextBuffer()
+	}
+	return readChunk, release, nil
+}
 
-	for i := 0; i < returnSize; i++ {
-		returnVal = append(returnVal, lines[i].Line)
+func lineStrings(lines []LineReturn) []string {
+	returnVal := make([]string, len(lines))
+	for i, line := range lines {
+		returnVal[i] = line.Line
 	}
 
-	return returnVal, lines[returnSize-1].Offset, nil
+	return returnVal
 }
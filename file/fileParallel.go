@@ -2,8 +2,9 @@ package file
 
 import (
 	"bytes"
-	"os"
-	"strings"
+	"fmt"
+	"runtime"
+	"sync"
 )
 
 // ReadLastLinesWithOffsetP reads the last initBufSize bytes in front of the fileOffset bytes before EOF
@@ -14,18 +15,12 @@ func ReadLastLinesWithOffsetP(fileName string, fileOffset int64, initBufSize int
 		return [][]byte{}, nil
 	}
 
-	file, err := os.Open(fileName)
+	file, fileSize, err := openReader(fileName)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer file.Close()
 
-	stat, err := os.Stat(fileName)
-	if err != nil {
-		panic(err)
-	}
-
-	fileSize := stat.Size()
 	// the fileOffset has exceeded the size of the file,
 	// i.e., we've scanned through the whole file
 	if fileSize <= fileOffset {
@@ -42,14 +37,8 @@ func ReadLastLinesWithOffsetP(fileName string, fileOffset int64, initBufSize int
 	}
 
 	buf := make([]byte, bufSize)
-	// ReadAt can't start from the very beginning of a file
-	if curBufStart == 0 {
-		_, err = file.Read(buf)
-	} else {
-		_, err = file.ReadAt(buf, curBufStart)
-	}
-	if err != nil {
-		panic(err)
+	if err := readBufferAt(file, buf, curBufStart); err != nil {
+		return nil, err
 	}
 
 	return RevertBufferByLineBreak(buf), nil
@@ -129,78 +118,279 @@ func CombineLines(first, second [][]byte) [][]byte {
 
 const FILE_OFFSET_UNIT_SIZE = 1 << 15
 
-// ReadLastNLinesWithKeywordP keeps calling ReadLastLinesWithOffsetP until we reach the target lines of log
-// if input query is not empty, log lines are filtered first before they are appended
+// ParallelChunkSize is the size of the chunks ReadLastNLinesMatchingP hands to the workers, FILE_OFFSET_UNIT_SIZE by default
+var ParallelChunkSize = FILE_OFFSET_UNIT_SIZE
+
+// ParallelWorkers is how many chunks of ParallelChunkSize bytes ReadLastNLinesMatchingP reads and filters at the same time
+var ParallelWorkers = runtime.NumCPU()
+
+// ParallelMemoryBudget caps the bytes of chunks that have been read but not merged into the result yet,
+// a slow merge holds back the workers instead of piling up chunks
+var ParallelMemoryBudget int64 = 64 << 20
+
+// ReadLastNLinesWithKeywordP reads the file backwards in chunks on several workers until we reach the target lines of log
+// if input query is not empty, log lines are filtered first before they are appended.
+// with a keyword index of the file (see BuildKeywordIndex) the chunks that can't have the keyword aren't read
 func ReadLastNLinesWithKeywordP(fileName string, n int, query string) ([][]byte, error) {
-	initBufSize := FILE_OFFSET_UNIT_SIZE
+	return ReadLastNLinesMatchingP(fileName, n, KeywordMatcher(query))
+}
 
-	return ReadLastNLinesWithKeywordPInternal(fileName, n, initBufSize, query)
+// ReadLastNLinesMatchingP is ReadLastNLinesWithKeywordP for any Matcher
+func ReadLastNLinesMatchingP(fileName string, n int, matcher Matcher) ([][]byte, error) {
+	initBufSize := ParallelChunkSize
+
+	return readLastNLinesMatchingPInternal(fileName, n, initBufSize, matcher)
 }
 
 // ReadLastNLinesWithKeywordPInternal is internal use only!!
 // expose the buf size for testing
 func ReadLastNLinesWithKeywordPInternal(fileName string, n int, initBufSize int, query string) ([][]byte, error) {
-	lines := [][]byte{}
-	fileOffsetCounter := 0
-	newlines := [][]byte{[]byte("dummy")}
-	var err error
-
-	// if we have a filter key word, things get a bit tricky because
-	// we can't blindly combine all the results with CombineLines
-	// instead we need to filter the lines that contain the keyword before appending to the result
-	// given that the lines at the border of two buffers might both be segmented
-	// we need to keep a record of the last line of the previous result
-	// and join this last line with the new result (bar new last line) and search for keyword
-	// the new last line will be stored for next time when we have new results
-	rollingLastLine := []byte{}
-
-	// try to read one more line in case the last line is segmented
-	for len(lines) < n+1 {
-		newlines, err = ReadLastLinesWithOffsetP(
-			fileName, int64(fileOffsetCounter*initBufSize), initBufSize)
+	return readLastNLinesMatchingPInternal(fileName, n, initBufSize, KeywordMatcher(query))
+}
+
+func readLastNLinesMatchingPInternal(fileName string, n int, initBufSize int, matcher Matcher) ([][]byte, error) {
+	if isCompressed(fileName) {
+		compressedLines, _, _, err := collectLastLinesCompressed(fileName, n, matcher, 0, newResultBudget())
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
-		if len(newlines) == 0 {
-			// if there's content in the rolling last line and we don't have any newlines coming
-			// then we've reached the beginning of the file
-			// we need to append the content if it matches the query
-			if len(rollingLastLine) > 0 {
-				if query == "" || strings.Contains(string(rollingLastLine), query) {
-					lines = CombineLines(lines, [][]byte{rollingLastLine})
-				}
-			}
-			break
+		// same as the lines below, they keep their line break
+		lines := make([][]byte, len(compressedLines))
+		for i, line := range compressedLines {
+			lines[i] = []byte(line.Line + "\n")
 		}
+		return lines, nil
+	}
+
+	return readChunksParallel(fileName, n, initBufSize, matcher, ParallelWorkers, ParallelMemoryBudget)
+}
 
-		if query == "" {
-			lines = CombineLines(lines, newlines)
-		} else {
-			combinedNewLines := [][]byte{}
-			// if there's only one new line, don't bother save it to the rolling last line
-			// because there won't be next round of buffer reading
-			if len(newlines) == 1 {
-				combinedNewLines = CombineLines([][]byte{rollingLastLine}, newlines)
-				rollingLastLine = []byte{}
-			} else {
-				combinedNewLines = CombineLines([][]byte{rollingLastLine}, newlines[:len(newlines)-1])
-				rollingLastLine = newlines[len(newlines)-1]
+// parallelChunk is what a worker makes of one chunk of the file
+// a line can start in one chunk and end in a later one, so the bytes in front of the first line break (head)
+// and behind the last one (tail) are handed back as they are, to be stitched to the neighbouring chunks.
+// the complete lines in between have already been filtered
+type parallelChunk struct {
+	head  []byte
+	lines [][]byte
+	tail  []byte
+	// there's no line break in the chunk at all, the whole chunk is in tail
+	noLineBreak bool
+	// the keyword index rules out every line with bytes in the chunk, it hasn't been read.
+	// the pieces of those lines in the neighbouring chunks are still matched but can't match either:
+	// the index only rules out keywords, a piece of a line doesn't have a keyword the line doesn't have
+	skipped bool
+	err     error
+}
+
+// readChunksParallel splits the file into chunks of chunkSize bytes counted from the start of the file,
+// the way the keyword index does, reads and filters them on a pool of workers, the last one first,
+// and merges the results chunk by chunk in order.
+// no more chunks are handed out once n lines have been found.
+// at most memoryBudget/chunkSize chunks are in flight (being read or waiting to be merged)
+func readChunksParallel(fileName string, n int, chunkSize int, matcher Matcher, workers int, memoryBudget int64) ([][]byte, error) {
+	lines := [][]byte{}
+	if n <= 0 || chunkSize <= 0 {
+		return lines, nil
+	}
+
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	inFlight := int(memoryBudget / int64(chunkSize))
+	if inFlight < 1 {
+		inFlight = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > inFlight {
+		workers = inFlight
+	}
+
+	type job struct {
+		start  int64
+		end    int64
+		result chan parallelChunk
+	}
+
+	canMatch := currentKeywordIndexer().chunkFilter(fileName, file, chunkSize, matcher)
+
+	chunks := (fileSize + int64(chunkSize) - 1) / int64(chunkSize)
+	jobs := make(chan job)
+	// the results in the order of the chunks, the merge waits for each of them in turn
+	ordered := make(chan chan parallelChunk, inFlight)
+	slots := make(chan struct{}, inFlight)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// stop the workers before closing the file they read from
+	defer func() {
+		close(done)
+		wg.Wait()
+		file.Close()
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		defer close(ordered)
+
+		for index := chunks - 1; index >= 0; index-- {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
 			}
 
-			for _, newline := range combinedNewLines {
-				if strings.Contains(string(newline), query) {
-					lines = append(lines, newline)
-				}
+			next := job{start: index * int64(chunkSize), end: (index + 1) * int64(chunkSize), result: make(chan parallelChunk, 1)}
+			if next.end > fileSize {
+				next.end = fileSize
+			}
+			ordered <- next.result
+			if canMatch != nil && !canMatch(index) {
+				next.result <- parallelChunk{skipped: true}
+				continue
+			}
+			select {
+			case jobs <- next:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next := range jobs {
+				next.result <- readParallelChunk(file, next.start, next.end, matcher)
 			}
+		}()
+	}
+
+	budget := newResultBudget()
+	// keep adds a line that has been filtered already, false once n lines are reached or the budget is used up
+	keep := func(line []byte) bool {
+		if len(lines) == n || !budget.take(len(line)) {
+			return false
 		}
+		lines = append(lines, line)
+		return true
+	}
+	// emit filters a line stitched together from the pieces of several chunks, dropped bytes of it have been cut off already
+	emit := func(line []byte, dropped int) bool {
+		line = cutParallelLine(line, dropped)
+		if len(line) > 0 && (isMatchAll(matcher) || matcher.Match(string(TrimLineBreak(line)))) {
+			return keep(line)
+		}
+		return true
+	}
 
-		fileOffsetCounter++
+	// the beginning of a line that continues in the chunks merged so far.
+	// the line is truncated anyway, so no more than MaxLineLength bytes of it are kept, dropped counts the rest
+	pending := []byte{}
+	dropped := 0
+	prepend := func(piece []byte) {
+		pending = append(piece, pending...)
+		if text := TrimLineBreak(pending); len(text) > MaxLineLength {
+			dropped += len(text) - MaxLineLength
+			pending = append(text[:MaxLineLength], pending[len(text):]...)
+		}
 	}
+	for result := range ordered {
+		chunk := <-result
+		<-slots
+		if chunk.err != nil {
+			return nil, chunk.err
+		}
+
+		if chunk.skipped {
+			// pending can be a whole line starting right at the end of the skipped chunk
+			if !emit(pending, dropped) {
+				return lines, nil
+			}
+			pending, dropped = []byte{}, 0
+			continue
+		}
+
+		prepend(chunk.tail)
+		if chunk.noLineBreak {
+			continue
+		}
 
-	if len(lines) >= n {
-		return lines[:n], nil
+		if !emit(pending, dropped) {
+			return lines, nil
+		}
+		for _, line := range chunk.lines {
+			if !keep(line) {
+				return lines, nil
+			}
+		}
+		pending, dropped = []byte{}, 0
+		prepend(chunk.head)
+
+		if len(lines) == n {
+			return lines, nil
+		}
 	}
 
+	// the first line of the file
+	emit(pending, dropped)
+
 	return lines, nil
 }
+
+// readParallelChunk reads the bytes between start and end and filters the lines that are complete within them.
+// what it returns is copied out of a mapped file, the merge uses it after the worker is done with the chunk
+func readParallelChunk(file Reader, start int64, end int64, matcher Matcher) parallelChunk {
+	chunk := parallelChunk{}
+	err := guardFaults(file, func() error {
+		buf, err := file.Slice(start, end)
+		if err != nil {
+			return err
+		}
+
+		first := bytes.IndexByte(buf, '\n')
+		if first == -1 {
+			chunk = parallelChunk{tail: detach(file, buf), noLineBreak: true}
+			return nil
+		}
+		last := bytes.LastIndexByte(buf, '\n')
+
+		chunk = parallelChunk{head: detach(file, buf[:first+1]), tail: detach(file, buf[last+1:]), lines: [][]byte{}}
+		if first == last {
+			return nil
+		}
+
+		for _, line := range RevertBufferByLineBreak(buf[first+1 : last+1]) {
+			line = cutParallelLine(line, 0)
+			if isMatchAll(matcher) || matcher.Match(string(TrimLineBreak(line))) {
+				chunk.lines = append(chunk.lines, detach(file, line))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return parallelChunk{err: err}
+	}
+
+	return chunk
+}
+
+// cutParallelLine is cutLine for the lines of the parallel reader, they keep their line break.
+// dropped bytes at the end of the line (in front of the line break) have been cut off already
+func cutParallelLine(line []byte, dropped int) []byte {
+	text := TrimLineBreak(line)
+	if len(text)+dropped <= MaxLineLength {
+		return line
+	}
+
+	// capped, the marker mustn't be appended in place
+	cut := append(text[:MaxLineLength:MaxLineLength], fmt.Sprintf(TRUNCATED_LINE_MARKER, len(text)+dropped-MaxLineLength)...)
+	return append(cut, line[len(text):]...)
+}
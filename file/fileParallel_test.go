@@ -2,8 +2,10 @@ package file_test
 
 import (
 	"cribl/logmonitor/file"
+	"fmt"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"strings"
 )
 
 var _ = Describe("ReadLastLinesWithOffsetP", func() {
@@ -355,3 +357,86 @@ var _ = Describe("RevertBufferByLineBreak", func() {
 		}))
 	})
 })
+
+var _ = Describe("ReadLastNLinesWithKeywordP with several workers", func() {
+	var fileName string
+
+	BeforeEach(func() {
+		// lines of all kinds of lengths, some longer than the chunks
+		lines := []string{}
+		for i := 1; i <= 500; i++ {
+			lines = append(lines, fmt.Sprintf("Line %d %s%s", i, strings.Repeat("x", (i*37)%300), []string{"", " error"}[i%7/6]))
+		}
+		fileName = writeTestFile(strings.Join(lines, "\n") + "\n")
+
+		workers, budget := file.ParallelWorkers, file.ParallelMemoryBudget
+		DeferCleanup(func() {
+			file.ParallelWorkers, file.ParallelMemoryBudget = workers, budget
+		})
+	})
+
+	withLineBreaks := func(lines []string) [][]byte {
+		expected := [][]byte{}
+		for _, line := range lines {
+			expected = append(expected, []byte(line+"\n"))
+		}
+		return expected
+	}
+
+	It("returns the same lines as the sequential reader", func() {
+		for _, workers := range []int{1, 3, 16} {
+			for _, bufSize := range []int{7, 64, 1000, 1 << 15} {
+				for _, query := range []string{"", "error", "Line 1"} {
+					for _, n := range []int{1, 10, 100, 1000} {
+						file.ParallelWorkers = workers
+						expected, err := file.ReadLastNLinesWithKeyword(fileName, n, query)
+						Expect(err).To(BeNil())
+
+						lines, err := file.ReadLastNLinesWithKeywordPInternal(fileName, n, bufSize, query)
+						Expect(err).To(BeNil())
+						Expect(lines).To(Equal(withLineBreaks(expected)), fmt.Sprintf("workers %d, buffer %d, query %q, n %d", workers, bufSize, query, n))
+					}
+				}
+			}
+		}
+	})
+
+	It("stays within the memory budget", func() {
+		// a budget smaller than a chunk still reads one chunk at a time
+		file.ParallelWorkers = 8
+		file.ParallelMemoryBudget = 1
+
+		expected, _ := file.ReadLastNLinesWithKeyword(fileName, 1000, "error")
+		lines, err := file.ReadLastNLinesWithKeywordPInternal(fileName, 1000, 64, "error")
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal(withLineBreaks(expected)))
+	})
+
+	It("truncates lines longer than MaxLineLength like the sequential reader", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 100
+
+		for _, bufSize := range []int{7, 64, 1000} {
+			for _, query := range []string{"", "error"} {
+				expected, err := file.ReadLastNLinesWithKeyword(fileName, 1000, query)
+				Expect(err).To(BeNil())
+
+				lines, err := file.ReadLastNLinesWithKeywordPInternal(fileName, 1000, bufSize, query)
+				Expect(err).To(BeNil())
+				Expect(lines).To(Equal(withLineBreaks(expected)), fmt.Sprintf("buffer %d, query %q", bufSize, query))
+			}
+		}
+	})
+
+	It("keeps the last line without a line break", func() {
+		fileName := writeTestFile("first\nsecond\nthird")
+
+		lines, err := file.ReadLastNLinesWithKeywordPInternal(fileName, 10, 4, "")
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([][]byte{[]byte("third"), []byte("second\n"), []byte("first\n")}))
+
+		lines, err = file.ReadLastNLinesWithKeywordPInternal(fileName, 0, 4, "")
+		Expect(err).To(BeNil())
+		Expect(lines).To(BeEmpty())
+	})
+})
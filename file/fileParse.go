@@ -0,0 +1,302 @@
+package file
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatAuto detects the format of every line on its own
+const FormatAuto Format = "auto"
+
+// ParsedLine is a log line split into its fields by ParseLine
+// Fields holds everything but timestamp, level and message. values of json lines that aren't strings are kept as json.
+// Raw is set when the line isn't in the expected format, Message is the whole line then
+type ParsedLine struct {
+	Line      string            `json:"line"`
+	Format    Format            `json:"format"`
+	Timestamp *time.Time        `json:"timestamp,omitempty"`
+	Level     string            `json:"level,omitempty"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Raw       bool              `json:"raw,omitempty"`
+}
+
+// the keys the usual loggers (zap, logrus, slog, bunyan, logstash...) use for the common fields
+var (
+	timestampKeys = []string{"timestamp", "time", "ts", "@timestamp", "t"}
+	levelKeys     = []string{"level", "lvl", "severity", "log.level", "loglevel"}
+	messageKeys   = []string{"message", "msg", "@message"}
+)
+
+var (
+	// <34>1 2003-10-11T22:14:15.003Z mymachine su - ID47 [exampleSDID@32473 iut="3"] message
+	syslog5424Pattern = regexp.MustCompile(`^<(\d{1,3})>\d (\S+) (\S+) (\S+) (\S+) (\S+) (-|(?:\[(?:[^\]\\]|\\.)*\])+) ?(.*)$`)
+	// <34>Oct 11 22:14:15 mymachine su[123]: message
+	syslog3164Pattern = regexp.MustCompile(`^(?:<(\d{1,3})>)?([A-Z][a-z]{2} [ \d]\d \d\d:\d\d:\d\d) (\S+) (?:([^:\[\s]+)(?:\[(\d+)\])?: ?)?(.*)$`)
+	// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "http://example.com/" "Mozilla/4.08"
+	clfFieldsPattern = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\d+|-)(?: "([^"]*)" "([^"]*)")?`)
+)
+
+// syslogSeverities are the names of the severities in the syslog priority
+var syslogSeverities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// ParseLine splits line into its fields, format is one of FormatJSON, FormatLogfmt, FormatSyslog, FormatCLF or FormatAuto
+func ParseLine(line string, format Format) ParsedLine {
+	if format == FormatAuto {
+		format = DetectLineFormat(line)
+	}
+
+	parsed := ParsedLine{Line: line, Format: format, Fields: map[string]string{}}
+	ok := false
+	switch format {
+	case FormatJSON:
+		ok = parsed.parseJSON()
+	case FormatLogfmt:
+		ok = parsed.parseLogfmt()
+	case FormatSyslog:
+		ok = parsed.parseSyslog()
+	case FormatCLF:
+		ok = parsed.parseCLF()
+	}
+
+	if !ok {
+		return ParsedLine{Line: line, Format: FormatPlain, Message: line, Raw: true}
+	}
+	if len(parsed.Fields) == 0 {
+		parsed.Fields = nil
+	}
+	return parsed
+}
+
+// ParseLines parses all of lines in format
+func ParseLines(lines []string, format Format) []ParsedLine {
+	parsed := make([]ParsedLine, len(lines))
+	for i, line := range lines {
+		parsed[i] = ParseLine(line, format)
+	}
+	return parsed
+}
+
+func (parsed *ParsedLine) parseJSON() bool {
+	decoder := json.NewDecoder(strings.NewReader(parsed.Line))
+	decoder.UseNumber()
+
+	values := map[string]any{}
+	if err := decoder.Decode(&values); err != nil {
+		return false
+	}
+
+	for key, value := range values {
+		switch value := value.(type) {
+		case string:
+			parsed.Fields[key] = value
+		default:
+			encoded, _ := json.Marshal(value)
+			parsed.Fields[key] = string(encoded)
+		}
+	}
+
+	parsed.takeCommonFields()
+	return true
+}
+
+func (parsed *ParsedLine) parseLogfmt() bool {
+	line := parsed.Line
+	pairs := 0
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			break
+		}
+
+		end := strings.IndexAny(line, "= \t")
+		if end == -1 {
+			end = len(line)
+		}
+		key := line[:end]
+		line = line[end:]
+		if key == "" {
+			return false
+		}
+
+		// a key without value is a flag
+		if !strings.HasPrefix(line, "=") {
+			parsed.Fields[key] = "true"
+			continue
+		}
+		line = line[1:]
+		pairs++
+
+		if strings.HasPrefix(line, `"`) {
+			end := closingQuote(line)
+			if end == -1 {
+				return false
+			}
+			value, err := strconv.Unquote(line[:end+1])
+			if err != nil {
+				return false
+			}
+			parsed.Fields[key] = value
+			line = line[end+1:]
+			continue
+		}
+
+		end = strings.IndexAny(line, " \t")
+		if end == -1 {
+			end = len(line)
+		}
+		parsed.Fields[key] = line[:end]
+		line = line[end:]
+	}
+
+	// plain text, not a single key=value
+	if pairs == 0 {
+		return false
+	}
+
+	parsed.takeCommonFields()
+	return true
+}
+
+// closingQuote returns the index of the quote closing the quoted string at the start of s, -1 if there's none
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+func (parsed *ParsedLine) parseSyslog() bool {
+	if match := syslog5424Pattern.FindStringSubmatch(parsed.Line); match != nil {
+		parsed.setSyslogPriority(match[1])
+		for i, key := range []string{"host", "app", "procid", "msgid", "structured_data"} {
+			if value := match[i+3]; value != "-" {
+				parsed.Fields[key] = value
+			}
+		}
+		parsed.Message = match[8]
+		if timestamp, ok := ExtractRFC3339Timestamp(match[2]); ok {
+			parsed.Timestamp = &timestamp
+		}
+		return true
+	}
+
+	match := syslog3164Pattern.FindStringSubmatch(parsed.Line)
+	if match == nil {
+		return false
+	}
+
+	parsed.setSyslogPriority(match[1])
+	for i, key := range []string{"host", "app", "pid"} {
+		if value := match[i+3]; value != "" {
+			parsed.Fields[key] = value
+		}
+	}
+	parsed.Message = match[6]
+	if timestamp, ok := ExtractSyslogTimestamp(match[2]); ok {
+		parsed.Timestamp = &timestamp
+	}
+	return true
+}
+
+func (parsed *ParsedLine) setSyslogPriority(priority string) {
+	if value, err := strconv.Atoi(priority); err == nil {
+		parsed.Fields["facility"] = strconv.Itoa(value / 8)
+		parsed.Level = syslogSeverities[value%8]
+	}
+}
+
+func (parsed *ParsedLine) parseCLF() bool {
+	match := clfFieldsPattern.FindStringSubmatch(parsed.Line)
+	if match == nil {
+		return false
+	}
+
+	for i, key := range []string{"remote_host", "ident", "user"} {
+		if value := match[i+1]; value != "-" {
+			parsed.Fields[key] = value
+		}
+	}
+	if timestamp, err := time.Parse(CLF_TIMESTAMP_LAYOUT, match[4]); err == nil {
+		parsed.Timestamp = &timestamp
+	}
+
+	parsed.Message = match[5]
+	if request := strings.Fields(match[5]); len(request) == 3 {
+		parsed.Fields["method"], parsed.Fields["path"], parsed.Fields["protocol"] = request[0], request[1], request[2]
+	}
+
+	parsed.Fields["status"] = match[6]
+	if match[7] != "-" {
+		parsed.Fields["bytes"] = match[7]
+	}
+	if match[8] != "" && match[8] != "-" {
+		parsed.Fields["referer"] = match[8]
+	}
+	if match[9] != "" && match[9] != "-" {
+		parsed.Fields["user_agent"] = match[9]
+	}
+
+	// access logs have no level, the status is the closest thing to it
+	switch match[6][0] {
+	case '5':
+		parsed.Level = "error"
+	case '4':
+		parsed.Level = "warn"
+	default:
+		parsed.Level = "info"
+	}
+	return true
+}
+
+// takeCommonFields moves timestamp, level and message out of Fields
+func (parsed *ParsedLine) takeCommonFields() {
+	if value, ok := parsed.takeField(timestampKeys); ok {
+		if timestamp, ok := parseTimestampValue(value); ok {
+			parsed.Timestamp = &timestamp
+		} else {
+			parsed.Fields["timestamp"] = value
+		}
+	}
+	if value, ok := parsed.takeField(levelKeys); ok {
+		parsed.Level = strings.ToLower(value)
+	}
+	if value, ok := parsed.takeField(messageKeys); ok {
+		parsed.Message = value
+	}
+}
+
+func (parsed *ParsedLine) takeField(keys []string) (string, bool) {
+	for _, key := range keys {
+		if value, ok := parsed.Fields[key]; ok {
+			delete(parsed.Fields, key)
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// parseTimestampValue parses the value of a timestamp field, a formatted time or seconds or milliseconds since the epoch
+func parseTimestampValue(value string) (time.Time, bool) {
+	if timestamp, ok := ExtractRFC3339Timestamp(value); ok {
+		return timestamp, true
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || strings.ContainsAny(value, "eE") {
+		return time.Time{}, false
+	}
+	// anything after 2286 in seconds is milliseconds
+	if number > 1e10 {
+		return time.UnixMilli(int64(number)), true
+	}
+	return time.Unix(0, int64(number*float64(time.Second))), true
+}
@@ -0,0 +1,80 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("ParseLine", func() {
+	It("parses json lines", func() {
+		parsed := file.ParseLine(`{"ts":"2026-10-17T14:02:03Z","level":"ERROR","msg":"request failed","status":500,"user":{"id":7}}`, file.FormatJSON)
+
+		Expect(parsed.Raw).To(BeFalse())
+		Expect(parsed.Format).To(Equal(file.FormatJSON))
+		Expect(parsed.Timestamp.Equal(time.Date(2026, 10, 17, 14, 2, 3, 0, time.UTC))).To(BeTrue())
+		Expect(parsed.Level).To(Equal("error"))
+		Expect(parsed.Message).To(Equal("request failed"))
+		Expect(parsed.Fields).To(Equal(map[string]string{"status": "500", "user": `{"id":7}`}))
+
+		parsed = file.ParseLine(`{"time":1792245723000,"message":"started"}`, file.FormatJSON)
+		Expect(parsed.Timestamp.Equal(time.Date(2026, 10, 17, 14, 2, 3, 0, time.UTC))).To(BeTrue())
+		Expect(parsed.Fields).To(BeNil())
+	})
+
+	It("parses logfmt lines", func() {
+		parsed := file.ParseLine(`time=2026-10-17T14:02:03Z level=warn msg="slow \"query\"" duration=1.5s cached`, file.FormatLogfmt)
+
+		Expect(parsed.Raw).To(BeFalse())
+		Expect(parsed.Timestamp).NotTo(BeNil())
+		Expect(parsed.Level).To(Equal("warn"))
+		Expect(parsed.Message).To(Equal(`slow "query"`))
+		Expect(parsed.Fields).To(Equal(map[string]string{"duration": "1.5s", "cached": "true"}))
+	})
+
+	It("parses syslog lines", func() {
+		parsed := file.ParseLine(`<34>1 2026-10-17T14:02:03.003Z mymachine su - ID47 - 'su root' failed`, file.FormatSyslog)
+		Expect(parsed.Raw).To(BeFalse())
+		Expect(parsed.Level).To(Equal("crit"))
+		Expect(parsed.Message).To(Equal("'su root' failed"))
+		Expect(parsed.Fields).To(Equal(map[string]string{"facility": "4", "host": "mymachine", "app": "su", "msgid": "ID47"}))
+
+		parsed = file.ParseLine(`Oct 17 14:02:03 mymachine sshd[123]: Accepted publickey`, file.FormatSyslog)
+		Expect(parsed.Raw).To(BeFalse())
+		Expect(parsed.Timestamp).NotTo(BeNil())
+		Expect(parsed.Message).To(Equal("Accepted publickey"))
+		Expect(parsed.Fields).To(Equal(map[string]string{"host": "mymachine", "app": "sshd", "pid": "123"}))
+	})
+
+	It("parses common log format lines", func() {
+		parsed := file.ParseLine(`127.0.0.1 - frank [17/Oct/2026:14:02:03 +0000] "GET /a.gif HTTP/1.0" 404 - "-" "curl/8.0"`, file.FormatCLF)
+
+		Expect(parsed.Raw).To(BeFalse())
+		Expect(parsed.Timestamp.Equal(time.Date(2026, 10, 17, 14, 2, 3, 0, time.UTC))).To(BeTrue())
+		Expect(parsed.Level).To(Equal("warn"))
+		Expect(parsed.Message).To(Equal("GET /a.gif HTTP/1.0"))
+		Expect(parsed.Fields).To(Equal(map[string]string{
+			"remote_host": "127.0.0.1", "user": "frank", "method": "GET", "path": "/a.gif", "protocol": "HTTP/1.0",
+			"status": "404", "user_agent": "curl/8.0",
+		}))
+	})
+
+	It("falls back to the raw line", func() {
+		for _, format := range []file.Format{file.FormatJSON, file.FormatLogfmt, file.FormatSyslog, file.FormatCLF, file.FormatAuto} {
+			parsed := file.ParseLine("Line 1 of the test file", format)
+			Expect(parsed).To(Equal(file.ParsedLine{
+				Line: "Line 1 of the test file", Format: file.FormatPlain, Message: "Line 1 of the test file", Raw: true,
+			}), string(format))
+		}
+	})
+
+	It("detects the format of each line with auto", func() {
+		parsed := file.ParseLines([]string{`{"msg":"a"}`, `msg=b level=info`, `plain`}, file.FormatAuto)
+		Expect(parsed[0].Format).To(Equal(file.FormatJSON))
+		Expect(parsed[0].Message).To(Equal("a"))
+		Expect(parsed[1].Format).To(Equal(file.FormatLogfmt))
+		Expect(parsed[1].Message).To(Equal("b"))
+		Expect(parsed[2].Raw).To(BeTrue())
+	})
+})
@@ -0,0 +1,508 @@
+package file
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// a query selects lines by their parsed fields (see ParseLine), for example
+//
+//	level:error AND status>=500 AND path:"/api/*" NOT user:healthcheck
+//
+// the grammar:
+//
+//	query      = or
+//	or         = and { "OR" and }
+//	and        = unary { [ "AND" ] unary }    terms next to each other are ANDed
+//	unary      = ( "NOT" | "-" ) unary | primary
+//	primary    = "(" or ")" | comparison | value
+//	comparison = field ( ":" | "=" | "!=" | ">" | ">=" | "<" | "<=" ) value
+//	value      = word | "quoted string"
+//
+// field:value matches case insensitively and supports the wildcards * and ?, field=value is an exact match.
+// > >= < <= compare numbers as numbers, timestamps as times, levels by severity and everything else as strings.
+// a value on its own matches lines that contain it, like keyword.
+// a field the line doesn't have matches no comparison
+
+// QueryError is a syntax error in a query, Position is the byte offset in the query where it was found
+type QueryError struct {
+	Query    string
+	Position int
+	Message  string
+}
+
+func (err *QueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", err.Message, err.Position)
+}
+
+// Unwrap makes errors.Is(err, ErrInvalidQuery) true for syntax errors
+func (err *QueryError) Unwrap() error {
+	return ErrInvalidQuery
+}
+
+// Marker returns the query with a ^ under the position of the error
+func (err *QueryError) Marker() string {
+	return err.Query + "\n" + strings.Repeat(" ", err.Position) + "^"
+}
+
+// QueryNode is a node of a parsed query
+// String returns the query in a canonical form, with parentheses around every AND and OR
+type QueryNode interface {
+	Evaluate(line ParsedLine) bool
+	String() string
+}
+
+// QueryAnd matches the lines all of Nodes match
+type QueryAnd struct {
+	Nodes []QueryNode
+}
+
+func (node QueryAnd) Evaluate(line ParsedLine) bool {
+	for _, child := range node.Nodes {
+		if !child.Evaluate(line) {
+			return false
+		}
+	}
+	return true
+}
+
+func (node QueryAnd) String() string {
+	return "(" + joinNodes(node.Nodes, " AND ") + ")"
+}
+
+// QueryOr matches the lines any of Nodes match
+type QueryOr struct {
+	Nodes []QueryNode
+}
+
+func (node QueryOr) Evaluate(line ParsedLine) bool {
+	for _, child := range node.Nodes {
+		if child.Evaluate(line) {
+			return true
+		}
+	}
+	return false
+}
+
+func (node QueryOr) String() string {
+	return "(" + joinNodes(node.Nodes, " OR ") + ")"
+}
+
+// QueryNot matches the lines Node doesn't match
+type QueryNot struct {
+	Node QueryNode
+}
+
+func (node QueryNot) Evaluate(line ParsedLine) bool {
+	return !node.Node.Evaluate(line)
+}
+
+func (node QueryNot) String() string {
+	return "NOT " + node.Node.String()
+}
+
+// QueryText matches the lines that contain Text
+type QueryText struct {
+	Text string
+}
+
+func (node QueryText) Evaluate(line ParsedLine) bool {
+	return strings.Contains(line.Line, node.Text)
+}
+
+func (node QueryText) String() string {
+	return strconv.Quote(node.Text)
+}
+
+// QueryComparison compares the field Field of a line with Value
+type QueryComparison struct {
+	Field    string
+	Operator string
+	Value    string
+	// pattern is the compiled Value of a : comparison
+	pattern *regexp.Regexp
+}
+
+func (node QueryComparison) Evaluate(line ParsedLine) bool {
+	value, timestamp, found := line.field(node.Field)
+	if !found {
+		return false
+	}
+
+	switch node.Operator {
+	case ":":
+		return node.pattern.MatchString(value)
+	case "=":
+		return value == node.Value
+	case "!=":
+		return value != node.Value
+	}
+
+	comparison, ok := compareQueryValues(value, timestamp, node.Value)
+	if strings.EqualFold(node.Field, "level") {
+		comparison, ok = compareLevels(value, node.Value)
+	}
+	if !ok {
+		return false
+	}
+	switch node.Operator {
+	case ">":
+		return comparison > 0
+	case ">=":
+		return comparison >= 0
+	case "<":
+		return comparison < 0
+	default:
+		return comparison <= 0
+	}
+}
+
+func (node QueryComparison) String() string {
+	return node.Field + node.Operator + strconv.Quote(node.Value)
+}
+
+// field returns the value of a field of the line, the timestamp field also as a time
+func (line ParsedLine) field(name string) (string, *time.Time, bool) {
+	switch strings.ToLower(name) {
+	case "line":
+		return line.Line, nil, true
+	case "message", "msg":
+		return line.Message, nil, true
+	case "format":
+		return string(line.Format), nil, true
+	case "level":
+		// lines that aren't structured may still say how severe they are
+		if line.Level == "" {
+			if level := ClassifyLevel(line.Line); level != LevelUnknown {
+				return level.String(), nil, true
+			}
+		}
+		return line.Level, nil, line.Level != ""
+	case "timestamp", "time", "ts":
+		if line.Timestamp == nil {
+			return "", nil, false
+		}
+		return line.Timestamp.Format(time.RFC3339Nano), line.Timestamp, true
+	}
+
+	value, found := line.Fields[name]
+	return value, nil, found
+}
+
+// compareQueryValues compares the value of a field with the value in the query, ok is false if they can't be compared
+func compareQueryValues(value string, timestamp *time.Time, queryValue string) (int, bool) {
+	if timestamp != nil {
+		other, ok := parseTimestampValue(queryValue)
+		if !ok {
+			return 0, false
+		}
+		return timestamp.Compare(other), true
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	otherNumber, otherErr := strconv.ParseFloat(queryValue, 64)
+	if err == nil && otherErr == nil {
+		switch {
+		case number < otherNumber:
+			return -1, true
+		case number > otherNumber:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return strings.Compare(value, queryValue), true
+}
+
+// compareLevels compares levels by severity, so that level>=warn includes error
+func compareLevels(value string, queryValue string) (int, bool) {
+	level, ok := ParseLevel(value)
+	otherLevel, otherOk := ParseLevel(queryValue)
+	if !ok || !otherOk {
+		return 0, false
+	}
+	return int(level - otherLevel), true
+}
+
+func joinNodes(nodes []QueryNode, separator string) string {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = node.String()
+	}
+	return strings.Join(parts, separator)
+}
+
+// ParseQuery parses query into its syntax tree, syntax errors are a *QueryError
+func ParseQuery(query string) (QueryNode, error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &queryParser{query: query, tokens: tokens}
+	if parser.peek().kind == queryEnd {
+		return nil, parser.errorf(parser.peek(), "empty query")
+	}
+
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != queryEnd {
+		return nil, parser.errorf(token, "unexpected %s", token)
+	}
+
+	return node, nil
+}
+
+// NewQueryMatcher compiles query into a Matcher for lines in format (see ParseLine)
+func NewQueryMatcher(query string, format Format) (Matcher, error) {
+	node, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	return QueryMatcher(node, format), nil
+}
+
+// QueryMatcher matches the lines that node matches once they're parsed in format
+func QueryMatcher(node QueryNode, format Format) Matcher {
+	return MatcherFunc(func(line string) bool {
+		return node.Evaluate(ParseLine(line, format))
+	})
+}
+
+type queryTokenKind int
+
+const (
+	queryEnd queryTokenKind = iota
+	queryWord
+	queryString
+	queryOperator
+	queryOpen
+	queryClose
+	queryMinus
+)
+
+type queryToken struct {
+	kind     queryTokenKind
+	text     string
+	position int
+}
+
+func (token queryToken) String() string {
+	switch token.kind {
+	case queryEnd:
+		return "end of query"
+	case queryString:
+		return strconv.Quote(token.text)
+	}
+	return fmt.Sprintf("%q", token.text)
+}
+
+// queryOperators are the comparison operators, longest first
+var queryOperators = []string{">=", "<=", "!=", ":", "=", ">", "<"}
+
+func tokenizeQuery(query string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	for position := 0; position < len(query); {
+		char := query[position]
+		switch {
+		case char == ' ' || char == '\t' || char == '\n':
+			position++
+			continue
+
+		case char == '(':
+			tokens = append(tokens, queryToken{queryOpen, "(", position})
+			position++
+			continue
+
+		case char == ')':
+			tokens = append(tokens, queryToken{queryClose, ")", position})
+			position++
+			continue
+
+		case char == '"':
+			end := closingQuote(query[position:])
+			if end == -1 {
+				return nil, &QueryError{Query: query, Position: position, Message: "unterminated string"}
+			}
+			text, err := strconv.Unquote(query[position : position+end+1])
+			if err != nil {
+				return nil, &QueryError{Query: query, Position: position, Message: "invalid string"}
+			}
+			tokens = append(tokens, queryToken{queryString, text, position})
+			position += end + 1
+			continue
+
+		// -term is NOT term, a - inside a word (or a negative number after an operator) is part of the word
+		case char == '-' && position+1 < len(query) && !isQuerySpace(query[position+1]) &&
+			(len(tokens) == 0 || tokens[len(tokens)-1].kind != queryOperator):
+			tokens = append(tokens, queryToken{queryMinus, "-", position})
+			position++
+			continue
+		}
+
+		if operator := queryOperatorAt(query, position); operator != "" && (len(tokens) == 0 || tokens[len(tokens)-1].kind != queryOperator) {
+			tokens = append(tokens, queryToken{queryOperator, operator, position})
+			position += len(operator)
+			continue
+		}
+
+		// a value may contain operators (times, urls...), a field name ends at the operator
+		isValue := len(tokens) > 0 && tokens[len(tokens)-1].kind == queryOperator
+		start := position
+		for position < len(query) && !isQuerySpace(query[position]) && !strings.ContainsRune(`()"`, rune(query[position])) &&
+			(isValue || queryOperatorAt(query, position) == "") {
+			position++
+		}
+		tokens = append(tokens, queryToken{queryWord, query[start:position], start})
+	}
+
+	return append(tokens, queryToken{queryEnd, "", len(query)}), nil
+}
+
+func queryOperatorAt(query string, position int) string {
+	for _, operator := range queryOperators {
+		if strings.HasPrefix(query[position:], operator) {
+			return operator
+		}
+	}
+	return ""
+}
+
+func isQuerySpace(char byte) bool {
+	return char == ' ' || char == '\t' || char == '\n'
+}
+
+type queryParser struct {
+	query  string
+	tokens []queryToken
+	next   int
+}
+
+func (parser *queryParser) peek() queryToken {
+	return parser.tokens[parser.next]
+}
+
+func (parser *queryParser) take() queryToken {
+	token := parser.tokens[parser.next]
+	if token.kind != queryEnd {
+		parser.next++
+	}
+	return token
+}
+
+func (parser *queryParser) errorf(token queryToken, format string, args ...any) error {
+	return &QueryError{Query: parser.query, Position: token.position, Message: fmt.Sprintf(format, args...)}
+}
+
+func isKeyword(token queryToken, keyword string) bool {
+	return token.kind == queryWord && token.text == keyword
+}
+
+func (parser *queryParser) parseOr() (QueryNode, error) {
+	nodes := []QueryNode{}
+	for {
+		node, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+
+		if !isKeyword(parser.peek(), "OR") {
+			break
+		}
+		parser.take()
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return QueryOr{Nodes: nodes}, nil
+}
+
+func (parser *queryParser) parseAnd() (QueryNode, error) {
+	nodes := []QueryNode{}
+	for {
+		node, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+
+		token := parser.peek()
+		if isKeyword(token, "AND") {
+			parser.take()
+			continue
+		}
+		// the next term is ANDed unless the group or the query ends or an OR follows
+		if token.kind == queryEnd || token.kind == queryClose || isKeyword(token, "OR") {
+			break
+		}
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return QueryAnd{Nodes: nodes}, nil
+}
+
+func (parser *queryParser) parseUnary() (QueryNode, error) {
+	if token := parser.peek(); isKeyword(token, "NOT") || token.kind == queryMinus {
+		parser.take()
+		node, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return QueryNot{Node: node}, nil
+	}
+
+	return parser.parsePrimary()
+}
+
+func (parser *queryParser) parsePrimary() (QueryNode, error) {
+	token := parser.take()
+	switch {
+	case token.kind == queryOpen:
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := parser.take(); closing.kind != queryClose {
+			return nil, parser.errorf(closing, "expected \")\" instead of %s", closing)
+		}
+		return node, nil
+
+	case token.kind == queryString:
+		return QueryText{Text: token.text}, nil
+
+	case token.kind == queryWord && !isKeyword(token, "AND") && !isKeyword(token, "OR"):
+		if parser.peek().kind != queryOperator {
+			return QueryText{Text: token.text}, nil
+		}
+
+		operator := parser.take()
+		value := parser.take()
+		if value.kind != queryWord && value.kind != queryString {
+			return nil, parser.errorf(value, "expected a value after %q instead of %s", operator.text, value)
+		}
+		return newQueryComparison(token.text, operator.text, value.text), nil
+	}
+
+	return nil, parser.errorf(token, "unexpected %s", token)
+}
+
+func newQueryComparison(field string, operator string, value string) QueryComparison {
+	comparison := QueryComparison{Field: field, Operator: operator, Value: value}
+	if operator == ":" {
+		pattern := regexp.QuoteMeta(value)
+		pattern = strings.ReplaceAll(pattern, `\*`, ".*")
+		pattern = strings.ReplaceAll(pattern, `\?`, ".")
+		comparison.pattern = regexp.MustCompile("(?is)^" + pattern + "$")
+	}
+	return comparison
+}
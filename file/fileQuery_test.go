@@ -0,0 +1,123 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"errors"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseQuery", func() {
+	It("parses the grammar", func() {
+		for query, expected := range map[string]string{
+			`level:error`:      `level:"error"`,
+			`error`:            `"error"`,
+			`"disk full"`:      `"disk full"`,
+			`a b c`:            `("a" AND "b" AND "c")`,
+			`a AND b OR c`:     `(("a" AND "b") OR "c")`,
+			`a OR b c`:         `("a" OR ("b" AND "c"))`,
+			`a AND (b OR c)`:   `("a" AND ("b" OR "c"))`,
+			`NOT a`:            `NOT "a"`,
+			`-a b`:             `(NOT "a" AND "b")`,
+			`NOT NOT a`:        `NOT NOT "a"`,
+			`x-ray`:            `"x-ray"`,
+			`delta>-1`:         `delta>"-1"`,
+			`status>=500 a!=b`: `(status>="500" AND a!="b")`,
+			`level:error AND status>=500 AND path:"/api/*" NOT user:healthcheck`: `(level:"error" AND status>="500" AND path:"/api/*" AND NOT user:"healthcheck")`,
+			`msg:"say \"hi\""`:        `msg:"say \"hi\""`,
+			`ts<2026-10-17T14:02:00Z`: `ts<"2026-10-17T14:02:00Z"`,
+		} {
+			node, err := file.ParseQuery(query)
+			Expect(err).To(BeNil(), query)
+			Expect(node.String()).To(Equal(expected), query)
+		}
+	})
+
+	It("reports where the syntax error is", func() {
+		for query, position := range map[string]int{
+			``:                  0,
+			`level:`:            6,
+			`a AND`:             5,
+			`(a OR b`:           7,
+			`a)`:                1,
+			`status>=(500)`:     8,
+			`msg:"unterminated`: 4,
+			`OR a`:              0,
+		} {
+			_, err := file.ParseQuery(query)
+			Expect(err).To(MatchError(file.ErrInvalidQuery), query)
+
+			queryErr := &file.QueryError{}
+			Expect(errors.As(err, &queryErr)).To(BeTrue())
+			Expect(queryErr.Position).To(Equal(position), query)
+		}
+
+		_, err := file.ParseQuery(`level:error AND`)
+		queryErr := &file.QueryError{}
+		errors.As(err, &queryErr)
+		Expect(queryErr.Marker()).To(Equal("level:error AND\n               ^"))
+		Expect(err.Error()).To(Equal(`unexpected end of query at position 15`))
+	})
+})
+
+var _ = Describe("NewQueryMatcher", func() {
+	lines := []string{
+		`{"level":"error","msg":"upstream failed","status":502,"path":"/api/users","user":"alice"}`,
+		`{"level":"error","msg":"probe failed","status":503,"path":"/api/health","user":"healthcheck"}`,
+		`{"level":"info","msg":"ok","status":200,"path":"/api/users","user":"bob"}`,
+		`{"level":"warn","msg":"slow","status":499,"path":"/static/app.js"}`,
+		`not json at all, status>=500`,
+	}
+
+	matching := func(query string) []int {
+		matcher, err := file.NewQueryMatcher(query, file.FormatAuto)
+		Expect(err).To(BeNil(), query)
+
+		indices := []int{}
+		for i, line := range lines {
+			if matcher.Match(line) {
+				indices = append(indices, i)
+			}
+		}
+		return indices
+	}
+
+	It("evaluates queries against the fields", func() {
+		Expect(matching(`level:error AND status>=500 AND path:"/api/*" NOT user:healthcheck`)).To(Equal([]int{0}))
+		Expect(matching(`level:ERROR`)).To(Equal([]int{0, 1}))
+		Expect(matching(`level=ERROR`)).To(BeEmpty())
+		Expect(matching(`status<500`)).To(Equal([]int{2, 3}))
+		// numbers compare as numbers, not as strings
+		Expect(matching(`status>=1000`)).To(BeEmpty())
+		Expect(matching(`path:/api/* OR level:warn`)).To(Equal([]int{0, 1, 2, 3}))
+		Expect(matching(`NOT user:*`)).To(Equal([]int{3, 4}))
+		Expect(matching(`user!=bob`)).To(Equal([]int{0, 1}))
+		Expect(matching(`msg:*failed`)).To(Equal([]int{0, 1}))
+		Expect(matching(`"status>=500"`)).To(Equal([]int{4}))
+		Expect(matching(`format:plain`)).To(Equal([]int{4}))
+	})
+
+	It("compares timestamps as times", func() {
+		matcher, err := file.NewQueryMatcher(`ts>=2026-10-17T14:02:00Z ts<1792245780000`, file.FormatLogfmt)
+		Expect(err).To(BeNil())
+
+		Expect(matcher.Match(`ts=2026-10-17T14:01:59Z msg=a`)).To(BeFalse())
+		Expect(matcher.Match(`ts=2026-10-17T16:02:30+02:00 msg=b`)).To(BeTrue())
+		Expect(matcher.Match(`ts=2026-10-17T14:03:00Z msg=c`)).To(BeFalse())
+		Expect(matcher.Match(`msg=d`)).To(BeFalse())
+	})
+
+	It("runs in the read loops", func() {
+		fileName := writeTestFile(lines[0] + "\n" + lines[1] + "\n" + lines[2] + "\n")
+		matcher, err := file.NewQueryMatcher(`user:alice OR user:bob`, file.FormatJSON)
+		Expect(err).To(BeNil())
+
+		result, err := file.ReadLastNLinesMatching(fileName, 10, matcher)
+		Expect(err).To(BeNil())
+		Expect(result).To(Equal([]string{lines[2], lines[0]}))
+
+		parallel, err := file.ReadLastNLinesMatchingP(fileName, 10, matcher)
+		Expect(err).To(BeNil())
+		Expect(parallel).To(Equal([][]byte{[]byte(lines[2] + "\n"), []byte(lines[0] + "\n")}))
+	})
+})
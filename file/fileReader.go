@@ -0,0 +1,186 @@
+package file
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"syscall"
+)
+
+// Reader is an open log file the readers read from, it stays open for all the chunks of a read.
+// Size is the size of the file when it was opened, what's appended later isn't read
+type Reader interface {
+	io.ReaderAt
+	// Slice returns the bytes between start and end. the mmap backend returns the mapped memory itself, without copying.
+	// the bytes must not be changed and are only valid until Close
+	Slice(start int64, end int64) ([]byte, error)
+	Size() int64
+	Name() string
+	Stat() (os.FileInfo, error)
+	Close() error
+}
+
+// ReaderBackend is how a Reader gets at the bytes of the file
+type ReaderBackend string
+
+const (
+	// ReaderBackendFile reads with pread into a buffer per chunk
+	ReaderBackendFile ReaderBackend = "file"
+	// ReaderBackendMmap maps the file read only and slices the lines out of the mapping.
+	// a file truncated while it's mapped makes the read fail with io.ErrUnexpectedEOF, like a short read
+	ReaderBackendMmap ReaderBackend = "mmap"
+)
+
+// DefaultReaderBackend is the backend the readers of this package use.
+// set it before serving any requests, like ReadBufferSize
+var DefaultReaderBackend = ReaderBackendFile
+
+// OpenReader opens fileName with backend
+func OpenReader(fileName string, backend ReaderBackend) (Reader, error) {
+	file, fileSize, err := openForRead(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case ReaderBackendFile:
+		return fileReader{File: file, size: fileSize}, nil
+	case ReaderBackendMmap:
+		reader, err := mapFile(file, fileSize)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	file.Close()
+	return nil, fmt.Errorf("unknown reader backend %q", backend)
+}
+
+// openReader is openForRead for the readers that jump around in the file, with DefaultReaderBackend
+func openReader(fileName string) (Reader, int64, error) {
+	reader, err := OpenReader(fileName, DefaultReaderBackend)
+	if err != nil {
+		return nil, 0, err
+	}
+	return reader, reader.Size(), nil
+}
+
+type fileReader struct {
+	*os.File
+	size int64
+}
+
+func (reader fileReader) Size() int64 {
+	return reader.size
+}
+
+func (reader fileReader) Slice(start int64, end int64) ([]byte, error) {
+	buf := make([]byte, end-start)
+	if err := readBufferAt(reader, buf, start); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+type mmapReader struct {
+	file *os.File
+	data []byte
+}
+
+func mapFile(file *os.File, fileSize int64) (*mmapReader, error) {
+	// an empty mapping isn't allowed
+	if fileSize == 0 {
+		return &mmapReader{file: file}, nil
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(fileSize), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("%s: mmap: %w", file.Name(), err)
+	}
+	return &mmapReader{file: file, data: data}, nil
+}
+
+func (reader *mmapReader) ReadAt(buf []byte, offset int64) (int, error) {
+	if offset < 0 || offset > int64(len(reader.data)) {
+		return 0, io.EOF
+	}
+
+	n := 0
+	err := guardFaults(reader, func() error {
+		n = copy(buf, reader.data[offset:])
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n < len(buf) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (reader *mmapReader) Slice(start int64, end int64) ([]byte, error) {
+	if start < 0 || end > int64(len(reader.data)) || start > end {
+		return nil, fmt.Errorf("%s: short read at %d: %w", reader.Name(), start, io.ErrUnexpectedEOF)
+	}
+	// capped, so appending to it copies instead of writing into the mapping
+	return reader.data[start:end:end], nil
+}
+
+func (reader *mmapReader) Size() int64 {
+	return int64(len(reader.data))
+}
+
+func (reader *mmapReader) Name() string {
+	return reader.file.Name()
+}
+
+func (reader *mmapReader) Stat() (os.FileInfo, error) {
+	return reader.file.Stat()
+}
+
+func (reader *mmapReader) Close() error {
+	if reader.data != nil {
+		if err := syscall.Munmap(reader.data); err != nil {
+			reader.file.Close()
+			return err
+		}
+		reader.data = nil
+	}
+	return reader.file.Close()
+}
+
+// guardFaults runs read, which touches the bytes of reader. a mapped page behind the end of a file
+// that has been truncated since it was mapped can't be read, the fault is returned as a short read
+// instead of crashing the server. the file backend has nothing to guard
+func guardFaults(reader Reader, read func() error) (err error) {
+	if _, mapped := reader.(*mmapReader); !mapped {
+		return read()
+	}
+
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+		if _, fault := recovered.(interface{ Addr() uintptr }); !fault {
+			panic(recovered)
+		}
+		err = fmt.Errorf("%s: truncated while mapped: %w", reader.Name(), io.ErrUnexpectedEOF)
+	}()
+
+	return read()
+}
+
+// detach copies line out of the mapping if reader is mapped, for lines that outlive the reader
+func detach(reader Reader, line []byte) []byte {
+	if _, mapped := reader.(*mmapReader); mapped {
+		return bytes.Clone(line)
+	}
+	return line
+}
@@ -0,0 +1,158 @@
+package file_test
+
+import (
+	"bufio"
+	"cribl/logmonitor/file"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _ = Describe("Reader backends", func() {
+	var fileName string
+
+	BeforeEach(func() {
+		fileName = writeTestLines(5000)
+		DeferCleanup(func() { file.DefaultReaderBackend = file.ReaderBackendFile })
+	})
+
+	// read returns what the readers make of the file with backend
+	read := func(backend file.ReaderBackend) []any {
+		file.DefaultReaderBackend = backend
+
+		lines, err := file.ReadLastNLinesMatching(fileName, 300, file.KeywordMatcher("9"))
+		Expect(err).To(BeNil())
+		plines, err := file.ReadLastNLinesMatchingP(fileName, 300, file.KeywordMatcher("7 of"))
+		Expect(err).To(BeNil())
+		skip, err := file.SkipLinesOffset(fileName, 4321)
+		Expect(err).To(BeNil())
+		page, offset, err := file.ReadLastNLinesMatchingPagination(fileName, 50, file.MatchAll, skip)
+		Expect(err).To(BeNil())
+		return []any{lines, plines, skip, page, offset}
+	}
+
+	It("reads the same lines with both backends", func() {
+		Expect(read(file.ReaderBackendMmap)).To(Equal(read(file.ReaderBackendFile)))
+	})
+
+	It("slices the mapped file without copying", func() {
+		reader, err := file.OpenReader(fileName, file.ReaderBackendMmap)
+		Expect(err).To(BeNil())
+		defer reader.Close()
+
+		first, err := reader.Slice(0, 24)
+		Expect(err).To(BeNil())
+		Expect(string(first)).To(Equal("Line 1 of the test file\n"))
+		again, err := reader.Slice(5, 24)
+		Expect(err).To(BeNil())
+		Expect(&again[0]).To(BeIdenticalTo(&first[5]))
+
+		_, err = reader.Slice(0, reader.Size()+1)
+		Expect(errors.Is(err, io.ErrUnexpectedEOF)).To(BeTrue())
+	})
+
+	It("reports a file truncated while it's mapped as a short read", func() {
+		reader, err := file.OpenReader(fileName, file.ReaderBackendMmap)
+		Expect(err).To(BeNil())
+		defer reader.Close()
+
+		Expect(os.Truncate(fileName, 0)).To(Succeed())
+		_, err = reader.ReadAt(make([]byte, 100), reader.Size()-100)
+		Expect(errors.Is(err, io.ErrUnexpectedEOF)).To(BeTrue())
+	})
+
+	It("maps empty files", func() {
+		empty := writeTestFile("")
+		file.DefaultReaderBackend = file.ReaderBackendMmap
+
+		lines, err := file.ReadLastNLinesMatching(empty, 10, file.MatchAll)
+		Expect(err).To(BeNil())
+		Expect(lines).To(BeEmpty())
+	})
+
+	It("rejects unknown backends", func() {
+		_, err := file.OpenReader(fileName, "tape")
+		Expect(err).To(MatchError(ContainSubstring("unknown reader backend")))
+	})
+})
+
+// BenchmarkReaders compares the backends on a 5MB and a 1GB file (skipped with -short):
+//
+//	go test ./file -run '^$' -bench Readers -benchmem
+func BenchmarkReaders(b *testing.B) {
+	sizes := []struct {
+		name  string
+		bytes int64
+	}{{"5MB", 5 << 20}, {"1GB", 1 << 30}}
+
+	for _, size := range sizes {
+		if testing.Short() && size.bytes > 5<<20 {
+			continue
+		}
+
+		fileName := writeBenchmarkFile(b, size.bytes)
+		b.Run(size.name, func(b *testing.B) {
+			for _, backend := range []file.ReaderBackend{file.ReaderBackendFile, file.ReaderBackendMmap} {
+				b.Run(string(backend), func(b *testing.B) {
+					file.DefaultReaderBackend = backend
+					defer func() { file.DefaultReaderBackend = file.ReaderBackendFile }()
+
+					// the newest lines, what most requests ask for
+					b.Run("tail", func(b *testing.B) {
+						for i := 0; i < b.N; i++ {
+							if _, err := file.ReadLastNLinesMatching(fileName, 100, file.MatchAll); err != nil {
+								b.Fatal(err)
+							}
+						}
+					})
+					// a keyword that isn't there, the whole file is read
+					b.Run("scan", func(b *testing.B) {
+						b.SetBytes(size.bytes)
+						for i := 0; i < b.N; i++ {
+							if _, err := file.ReadLastNLinesMatching(fileName, 100, file.KeywordMatcher("nowhere")); err != nil {
+								b.Fatal(err)
+							}
+						}
+					})
+					b.Run("parallel-scan", func(b *testing.B) {
+						b.SetBytes(size.bytes)
+						for i := 0; i < b.N; i++ {
+							if _, err := file.ReadLastNLinesMatchingP(fileName, 100, file.KeywordMatcher("nowhere")); err != nil {
+								b.Fatal(err)
+							}
+						}
+					})
+				})
+			}
+		})
+	}
+}
+
+// writeBenchmarkFile writes a log of about size bytes, removed once the benchmark is done
+func writeBenchmarkFile(b *testing.B, size int64) string {
+	dir := b.TempDir()
+	fileName := filepath.Join(dir, "bench.log")
+	out, err := os.Create(fileName)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer out.Close()
+
+	writer := bufio.NewWriterSize(out, 1<<20)
+	for i, written := 0, int64(0); written < size; i++ {
+		n, err := fmt.Fprintf(writer, "2023-08-20T18:04:45.%06dZ INFO request %d served path=/api/v1/logs status=200\n", i%1000000, i)
+		if err != nil {
+			b.Fatal(err)
+		}
+		written += int64(n)
+	}
+	if err := writer.Flush(); err != nil {
+		b.Fatal(err)
+	}
+	return fileName
+}
@@ -0,0 +1,206 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrPathNotAllowed = errors.New("path is not allowed")
+
+// Root is a directory logs can be read from
+// Allow and Deny are glob patterns (see filepath.Match) for the paths relative to Path.
+// a pattern without a / is matched against the file name only, so *.log covers nginx/access.log too.
+// a file needs to match one of Allow (if there are any) and none of Deny
+type Root struct {
+	Path  string   `json:"path" yaml:"path"`
+	Allow []string `json:"allow,omitempty" yaml:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty" yaml:"deny,omitempty"`
+}
+
+// Roots are the directories the api serves, the first one is searched first
+type Roots []Root
+
+// Resolve turns a file name from a request into the path of a file under one of roots
+// after resolving symlinks, the file has to be inside the root and allowed by it.
+// returns ErrPathNotAllowed if it isn't and ErrFileNotFound if the file doesn't exist in any root
+func (roots Roots) Resolve(name string) (string, error) {
+	var notAllowed error
+	for _, root := range roots {
+		resolved, err := root.resolve(name)
+		if err == nil {
+			return resolved, nil
+		}
+		if errors.Is(err, ErrPathNotAllowed) && notAllowed == nil {
+			notAllowed = err
+		} else if !errors.Is(err, ErrFileNotFound) {
+			return "", err
+		}
+	}
+
+	if notAllowed != nil {
+		return "", notAllowed
+	}
+	return "", fmt.Errorf("%w: %s", ErrFileNotFound, name)
+}
+
+// Glob returns the allowed regular files matching pattern in all of roots
+func (roots Roots) Glob(pattern string) ([]string, error) {
+	if !filepath.IsLocal(pattern) {
+		return nil, fmt.Errorf("%w: %s", ErrPathNotAllowed, pattern)
+	}
+
+	fileNames := []string{}
+	for _, root := range roots {
+		matches, err := filepath.Glob(filepath.Join(root.Path, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			relative, _ := filepath.Rel(root.Path, match)
+			resolved, err := root.resolve(relative)
+			if err != nil {
+				// not allowed or gone in the meantime
+				continue
+			}
+			if stat, err := os.Stat(resolved); err == nil && stat.Mode().IsRegular() {
+				fileNames = append(fileNames, resolved)
+			}
+		}
+	}
+
+	return fileNames, nil
+}
+
+// Relative returns path relative to the root it is in, for showing it to clients
+func (roots Roots) Relative(path string) string {
+	for _, root := range roots {
+		rootPath, err := filepath.EvalSymlinks(root.Path)
+		if err != nil {
+			continue
+		}
+		if relative, ok := relativeInside(rootPath, path); ok {
+			return relative
+		}
+	}
+
+	return filepath.Base(path)
+}
+
+// RelativeMessage replaces the paths inside the roots in message (of an error) with their Relative form,
+// so error responses don't give away where the roots are. a root itself becomes "."
+func (roots Roots) RelativeMessage(message string) string {
+	rootPaths := []string{}
+	for _, root := range roots {
+		rootPaths = append(rootPaths, filepath.Clean(root.Path))
+		if rootPath, err := filepath.EvalSymlinks(root.Path); err == nil {
+			rootPaths = append(rootPaths, rootPath)
+		}
+	}
+	// nested roots, the longer path first
+	sort.Slice(rootPaths, func(i, j int) bool { return len(rootPaths[i]) > len(rootPaths[j]) })
+
+	for _, rootPath := range rootPaths {
+		if rootPath != string(filepath.Separator) {
+			message = replaceRootPath(message, rootPath)
+		}
+	}
+	return message
+}
+
+// replaceRootPath cuts rootPath off the paths in message that start with it.
+// /var/log doesn't start /var/logs/x, the path has to go on with a separator or end there
+func replaceRootPath(message string, rootPath string) string {
+	var replaced strings.Builder
+	for {
+		index := strings.Index(message, rootPath)
+		if index == -1 {
+			replaced.WriteString(message)
+			return replaced.String()
+		}
+
+		replaced.WriteString(message[:index])
+		rest := message[index+len(rootPath):]
+		switch {
+		case strings.HasPrefix(rest, string(filepath.Separator)):
+			rest = rest[1:]
+		case rest == "" || !isPathChar(rest[0]):
+			replaced.WriteString(".")
+		default:
+			replaced.WriteString(rootPath)
+		}
+		message = rest
+	}
+}
+
+func isPathChar(char byte) bool {
+	return char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' ||
+		char == '.' || char == '-' || char == '_'
+}
+
+func (root Root) resolve(name string) (string, error) {
+	// reject ../ and absolute paths before touching the file system
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("%w: %s", ErrPathNotAllowed, name)
+	}
+
+	rootPath, err := filepath.EvalSymlinks(root.Path)
+	if err != nil {
+		return "", translateOpenError(root.Path, err)
+	}
+
+	// a symlink inside the root may point anywhere
+	resolved, err := filepath.EvalSymlinks(filepath.Join(rootPath, name))
+	if err != nil {
+		return "", translateOpenError(name, err)
+	}
+
+	relative, ok := relativeInside(rootPath, resolved)
+	if !ok {
+		return "", fmt.Errorf("%w: %s resolves to a file outside of its root", ErrPathNotAllowed, name)
+	}
+
+	if !root.allows(relative) {
+		return "", fmt.Errorf("%w: %s", ErrPathNotAllowed, name)
+	}
+
+	return resolved, nil
+}
+
+// allows checks the allow and deny lists of root for a path relative to it
+func (root Root) allows(relative string) bool {
+	if len(root.Allow) > 0 && !matchesAny(root.Allow, relative) {
+		return false
+	}
+
+	return !matchesAny(root.Deny, relative)
+}
+
+func matchesAny(patterns []string, relative string) bool {
+	for _, pattern := range patterns {
+		target := relative
+		if !strings.Contains(pattern, "/") {
+			target = filepath.Base(relative)
+		}
+
+		if matched, _ := filepath.Match(pattern, filepath.ToSlash(target)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// relativeInside returns path relative to dir if it is inside of dir
+func relativeInside(dir string, path string) (string, bool) {
+	relative, err := filepath.Rel(dir, path)
+	if err != nil || !filepath.IsLocal(relative) {
+		return "", false
+	}
+
+	return relative, true
+}
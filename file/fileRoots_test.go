@@ -0,0 +1,108 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Roots", func() {
+	var root, outside string
+
+	BeforeEach(func() {
+		var err error
+		root, err = os.MkdirTemp("", "logmonitor-root")
+		Expect(err).To(BeNil())
+		DeferCleanup(os.RemoveAll, root)
+		root, _ = filepath.EvalSymlinks(root)
+
+		outside = writeTestFile("secret\n")
+
+		Expect(os.MkdirAll(filepath.Join(root, "nginx"), 0755)).To(Succeed())
+		for _, name := range []string{"syslog", "secure", "nginx/access.log", "nginx/error.log"} {
+			Expect(os.WriteFile(filepath.Join(root, name), []byte("line\n"), 0644)).To(Succeed())
+		}
+		Expect(os.Symlink(outside, filepath.Join(root, "escape.log"))).To(Succeed())
+		Expect(os.Symlink(filepath.Join(root, "syslog"), filepath.Join(root, "nginx", "syslog.log"))).To(Succeed())
+	})
+
+	It("resolves files inside the root", func() {
+		roots := file.Roots{{Path: root}}
+
+		path, err := roots.Resolve("nginx/access.log")
+		Expect(err).To(BeNil())
+		Expect(path).To(Equal(filepath.Join(root, "nginx/access.log")))
+
+		path, err = roots.Resolve("nginx/../syslog")
+		Expect(err).To(BeNil())
+		Expect(path).To(Equal(filepath.Join(root, "syslog")))
+
+		// symlinks are fine as long as they stay inside
+		path, err = roots.Resolve("nginx/syslog.log")
+		Expect(err).To(BeNil())
+		Expect(path).To(Equal(filepath.Join(root, "syslog")))
+	})
+
+	It("rejects paths leaving the root", func() {
+		roots := file.Roots{{Path: root}}
+
+		for _, name := range []string{"../../etc/shadow", "nginx/../../etc/shadow", "/etc/shadow", "escape.log"} {
+			_, err := roots.Resolve(name)
+			Expect(err).To(MatchError(file.ErrPathNotAllowed), name)
+		}
+
+		_, err := roots.Resolve("nope.log")
+		Expect(err).To(MatchError(file.ErrFileNotFound))
+	})
+
+	It("applies the allow and deny lists", func() {
+		roots := file.Roots{{Path: root, Allow: []string{"*.log", "sys*"}, Deny: []string{"nginx/error.log"}}}
+
+		_, err := roots.Resolve("nginx/access.log")
+		Expect(err).To(BeNil())
+		_, err = roots.Resolve("syslog")
+		Expect(err).To(BeNil())
+
+		_, err = roots.Resolve("secure")
+		Expect(err).To(MatchError(file.ErrPathNotAllowed))
+		_, err = roots.Resolve("nginx/error.log")
+		Expect(err).To(MatchError(file.ErrPathNotAllowed))
+	})
+
+	It("searches the roots in order", func() {
+		other, _ := filepath.EvalSymlinks(filepath.Dir(outside))
+		roots := file.Roots{{Path: root, Deny: []string{"test.log"}}, {Path: other}}
+
+		path, err := roots.Resolve("test.log")
+		Expect(err).To(BeNil())
+		Expect(path).To(Equal(filepath.Join(other, "test.log")))
+		Expect(roots.Relative(path)).To(Equal("test.log"))
+	})
+
+	It("takes the roots out of error messages", func() {
+		roots := file.Roots{{Path: root}}
+
+		_, err := file.ReadLastNLinesWithKeyword(filepath.Join(root, "nginx/gone.log"), 1, "")
+		Expect(err).To(MatchError(file.ErrFileNotFound))
+		Expect(roots.RelativeMessage(err.Error())).To(Equal("file not found: nginx/gone.log"))
+
+		Expect(roots.RelativeMessage("can't read " + root + " or " + root + "s/x")).To(Equal("can't read . or " + root + "s/x"))
+	})
+
+	It("globs the allowed files", func() {
+		roots := file.Roots{{Path: root, Deny: []string{"error.log"}}}
+
+		fileNames, err := roots.Glob("nginx/*.log")
+		Expect(err).To(BeNil())
+		Expect(fileNames).To(ConsistOf(filepath.Join(root, "nginx/access.log"), filepath.Join(root, "syslog")))
+
+		fileNames, err = roots.Glob("*.log")
+		Expect(err).To(BeNil())
+		Expect(fileNames).To(BeEmpty())
+
+		_, err = roots.Glob("../*")
+		Expect(err).To(MatchError(file.ErrPathNotAllowed))
+	})
+})
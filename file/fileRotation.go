@@ -0,0 +1,222 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// rotatedFileExtensions are the extensions RotationChain looks for behind fileName.N
+var rotatedFileExtensions = []string{"", ".gz", ".zst", ".bz2"}
+
+// RotationChain returns fileName followed by its rotated copies that exist on disk, newest first.
+// logrotate renames fileName to fileName.1, fileName.1 to fileName.2 and so on,
+// and optionally compresses them into fileName.2.gz (or .zst, .bz2)
+func RotationChain(fileName string) []string {
+	chain := []string{fileName}
+	for i := 1; ; i++ {
+		found := false
+		for _, extension := range rotatedFileExtensions {
+			candidate := fmt.Sprintf("%s.%d%s", fileName, i, extension)
+			if _, err := os.Stat(candidate); err == nil {
+				chain = append(chain, candidate)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return chain
+		}
+	}
+}
+
+type rotationMember struct {
+	fileName string
+	identity FileIdentity
+}
+
+// ReadPage reads up to n lines that matcher matches, newest first, starting at cursor
+// or at the end of fileName if cursor is nil.
+// once it reaches the beginning of fileName it carries on with the rotated copies of it (see RotationChain)
+// and because the cursor follows the inode, a cursor stays valid after the file it points into is rotated.
+// returns the cursor of the next page, nil if there is nothing older left
+func ReadPage(fileName string, n int, matcher Matcher, cursor *Cursor) ([]string, *Cursor, error) {
+	lines, _, _, next, err := ReadPageWithOffsets(fileName, n, matcher, cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lineStrings(lines), next, nil
+}
+
+// ReadPageWithOffsets is ReadPage with the offset of each line.
+// the first own lines are lines of fileName, their offsets are counted back from fileSize, its size as of now (see ReadPageForward).
+// the offsets of the lines behind them are counted back from the end of the rotated copy they are in
+func ReadPageWithOffsets(
+	fileName string, n int, matcher Matcher, cursor *Cursor) (lines []LineReturn, own int, fileSize int64, next *Cursor, err error) {
+	if cursor != nil && cursor.Forward {
+		return nil, 0, 0, nil, ErrInvalidCursor
+	}
+
+	members := []rotationMember{}
+	for _, name := range RotationChain(fileName) {
+		identity, err := GetFileIdentity(name)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+		members = append(members, rotationMember{name, identity})
+	}
+
+	start := 0
+	var offset int64 = 0
+	if cursor != nil {
+		start = -1
+		for i, member := range members {
+			if member.identity.SameFile(cursor.Identity()) {
+				start = i
+				break
+			}
+		}
+		if start == -1 {
+			return nil, 0, 0, nil, ErrCursorFileChanged
+		}
+
+		offset, err = ResolveCursor(*cursor, members[start].identity)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+	}
+
+	lines = []LineReturn{}
+	fileSize = members[0].identity.Size
+	// shared by all the files of the page
+	budget := newResultBudget()
+	for i := start; i < len(members); i++ {
+		member := members[i]
+		if len(lines) >= n {
+			return lines, own, fileSize, member.cursor(offset), nil
+		}
+
+		newlines, nextOffset, total, err := member.readLastLines(n-len(lines), matcher, offset, budget)
+		if err != nil {
+			return nil, 0, 0, nil, err
+		}
+
+		lines = append(lines, newlines...)
+		if i == 0 {
+			own = len(lines)
+		}
+		if nextOffset < total {
+			return lines, own, fileSize, member.cursor(nextOffset), nil
+		}
+
+		offset = 0
+	}
+
+	return lines, own, fileSize, nil, nil
+}
+
+// ReadPageForward reads up to n lines that matcher matches, oldest first, starting at cursor.
+// a forward cursor starts out as the identity of fileName (see GetFileIdentity) with the offset of the first line to read
+// and Forward set. unlike ReadPage it stays within fileName, once it has been rotated the cursor is rejected.
+// returns the cursor of the next page and whether there are lines behind it already.
+// at the end of the file the cursor points behind the last complete line, to pick up the lines appended later
+// (and the rest of a line that was still being written)
+func ReadPageForward(fileName string, n int, matcher Matcher, cursor Cursor) ([]LineReturn, *Cursor, bool, error) {
+	if !cursor.Forward {
+		return nil, nil, false, ErrInvalidCursor
+	}
+
+	identity, err := GetFileIdentity(fileName)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	offset, err := ResolveCursor(cursor, identity)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	member := rotationMember{fileName, identity}
+	lines, nextOffset, more, err := member.readNextLines(n, matcher, offset, newResultBudget())
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	next := member.cursor(nextOffset)
+	next.Forward = true
+	return lines, next, more, nil
+}
+
+func (member rotationMember) cursor(offset int64) *Cursor {
+	return &Cursor{
+		Offset: offset,
+		Device: member.identity.Device,
+		Inode:  member.identity.Inode,
+		Size:   member.identity.Size,
+	}
+}
+
+// open opens the member, the file might have been rotated or truncated since we looked it up
+func (member rotationMember) open() (Reader, error) {
+	file, fileSize, err := openReader(member.fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !identityFromFileInfo(stat).SameFile(member.identity) || fileSize < member.identity.Size {
+		file.Close()
+		return nil, ErrCursorFileChanged
+	}
+
+	return file, nil
+}
+
+// readLastLines reads up to n lines that matcher matches in front of offset
+// offsets are counted from the size of the file when the member was looked up, anything appended since is ignored
+// returns the lines, the offset the next read should start from and the size offsets are counted from
+func (member rotationMember) readLastLines(
+	n int, matcher Matcher, offset int64, budget *resultBudget) ([]LineReturn, int64, int64, error) {
+	if isCompressed(member.fileName) {
+		return collectLastLinesCompressed(member.fileName, n, matcher, offset, budget)
+	}
+
+	file, err := member.open()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+
+	reader := NewBackwardLineReader(file, member.identity.Size)
+	if _, err := reader.Seek(-offset, io.SeekEnd); err != nil {
+		return nil, 0, 0, err
+	}
+	lines, nextOffset, err := collectBackwardLines(reader, n, matcher, budget)
+
+	return lines, nextOffset, member.identity.Size, err
+}
+
+// readNextLines reads up to n lines that matcher matches from offset on, oldest first, like readLastLines.
+// returns the lines, the offset the next read should start from and whether there are complete lines behind it
+func (member rotationMember) readNextLines(
+	n int, matcher Matcher, offset int64, budget *resultBudget) ([]LineReturn, int64, bool, error) {
+	if isCompressed(member.fileName) {
+		return nil, 0, false, fmt.Errorf("%w: %s", ErrCompressedForward, member.fileName)
+	}
+
+	file, err := member.open()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer file.Close()
+
+	reader := NewForwardLineReader(file, member.identity.Size)
+	if _, err := reader.Seek(-offset, io.SeekEnd); err != nil {
+		return nil, 0, false, err
+	}
+	return collectForwardLines(reader, n, matcher, budget)
+}
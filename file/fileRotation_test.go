@@ -0,0 +1,155 @@
+package file_test
+
+import (
+	"compress/gzip"
+	"context"
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"sync"
+)
+
+func writeGzipFile(fileName string, content string) {
+	f, err := os.Create(fileName)
+	Expect(err).To(BeNil())
+	defer f.Close()
+
+	writer := gzip.NewWriter(f)
+	writer.Write([]byte(content))
+	Expect(writer.Close()).To(Succeed())
+}
+
+func appendToFile(fileName string, content string) {
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	Expect(err).To(BeNil())
+	defer f.Close()
+	f.WriteString(content)
+}
+
+var _ = Describe("ReadPage", func() {
+	It("continues into the rotated copies of the file", func() {
+		fileName := writeTestFile("current 1\ncurrent 2\n")
+		Expect(os.WriteFile(fileName+".1", []byte("first 1\nfirst 2\n"), 0644)).To(Succeed())
+		writeGzipFile(fileName+".2.gz", "second 1\nsecond 2\nsecond 3\n")
+
+		Expect(file.RotationChain(fileName)).To(Equal([]string{fileName, fileName + ".1", fileName + ".2.gz"}))
+
+		lines, cursor, err := file.ReadPage(fileName, 3, file.MatchAll, nil)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"current 2", "current 1", "first 2"}))
+		Expect(cursor).NotTo(BeNil())
+
+		lines, cursor, err = file.ReadPage(fileName, 3, file.MatchAll, cursor)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"first 1", "second 3", "second 2"}))
+
+		lines, cursor, err = file.ReadPage(fileName, 3, file.MatchAll, cursor)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"second 1"}))
+		Expect(cursor).To(BeNil())
+	})
+
+	It("filters the compressed copies", func() {
+		fileName := writeTestFile("current 1\n")
+		writeGzipFile(fileName+".1.gz", "match 1\nother\nmatch 2\nother\n")
+
+		lines, cursor, err := file.ReadPage(fileName, 10, file.KeywordMatcher("match"), nil)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"match 2", "match 1"}))
+		Expect(cursor).To(BeNil())
+	})
+
+	It("keeps a cursor valid after the file is rotated", func() {
+		fileName := writeTestLines(5)
+
+		lines, cursor, err := file.ReadPage(fileName, 2, file.MatchAll, nil)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"Line 5 of the test file", "Line 4 of the test file"}))
+
+		// rotate, the old file gets one more line before the writer lets go of it
+		Expect(os.Rename(fileName, fileName+".1")).To(Succeed())
+		appendToFile(fileName+".1", "Line 6 of the test file\n")
+		appendToFile(fileName, "Line 1 of the new file\n")
+
+		lines, cursor, err = file.ReadPage(fileName, 4, file.MatchAll, cursor)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{
+			"Line 3 of the test file", "Line 2 of the test file", "Line 1 of the test file"}))
+		Expect(cursor).To(BeNil())
+	})
+
+	It("rejects a cursor once the file is truncated", func() {
+		fileName := writeTestLines(5)
+
+		_, cursor, err := file.ReadPage(fileName, 2, file.MatchAll, nil)
+		Expect(err).To(BeNil())
+
+		Expect(os.Truncate(fileName, 0)).To(Succeed())
+		_, _, err = file.ReadPage(fileName, 2, file.MatchAll, cursor)
+		Expect(err).To(Equal(file.ErrCursorFileChanged))
+	})
+
+	It("ignores lines appended after the cursor was issued", func() {
+		fileName := writeTestLines(5)
+		identity, _ := file.GetFileIdentity(fileName)
+		appendToFile(fileName, "Line 6 of the test file\n")
+
+		lines, _, err := file.ReadPage(fileName, 1, file.MatchAll, &file.Cursor{
+			Device: identity.Device, Inode: identity.Inode, Size: identity.Size})
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"Line 5 of the test file"}))
+	})
+})
+
+var _ = Describe("FollowFile across rotation and truncation", func() {
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	collected := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, lines...)
+	}
+
+	follow := func(ctx context.Context, fileName string) {
+		mu.Lock()
+		lines = []string{}
+		mu.Unlock()
+
+		identity, _ := file.GetFileIdentity(fileName)
+		go file.FollowFile(ctx, fileName, identity.Size, file.MatchAll, func(line string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			lines = append(lines, line)
+			return nil
+		})
+	}
+
+	It("switches to the new file after rotation", func() {
+		fileName := writeTestFile("old 1\n")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		follow(ctx, fileName)
+
+		appendToFile(fileName, "old 2\n")
+		Eventually(collected).Should(Equal([]string{"old 2"}))
+
+		Expect(os.Rename(fileName, fileName+".1")).To(Succeed())
+		appendToFile(fileName+".1", "old 3\n")
+		appendToFile(fileName, "new 1\n")
+		Eventually(collected).Should(Equal([]string{"old 2", "old 3", "new 1"}))
+	})
+
+	It("starts over when the file is truncated", func() {
+		fileName := writeTestFile("line 1\nline 2\n")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		follow(ctx, fileName)
+
+		Expect(os.Truncate(fileName, 0)).To(Succeed())
+		appendToFile(fileName, "after\n")
+		Eventually(collected).Should(Equal([]string{"after"}))
+	})
+})
@@ -0,0 +1,141 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var ErrTooManyBuckets = errors.New("too many buckets")
+
+// MAX_STATS_BUCKETS is the most buckets CollectStats returns, a longer range needs bigger buckets
+const MAX_STATS_BUCKETS = 10000
+
+// DEFAULT_STATS_BUCKETS is how many buckets CollectStats counts without timeRange.Since
+const DEFAULT_STATS_BUCKETS = 100
+
+var errTooManyBuckets = fmt.Errorf("%w: the range can have at most %d buckets, use bigger ones", ErrTooManyBuckets, MAX_STATS_BUCKETS)
+
+// Stats counts the lines in a time range, in total and per bucket
+type Stats struct {
+	Total  int           `json:"total"`
+	Levels map[Level]int `json:"levels"`
+	// Buckets are oldest first, buckets without lines included
+	Buckets []StatsBucket `json:"buckets"`
+}
+
+// StatsBucket counts the lines written from Start for the bucket's duration
+type StatsBucket struct {
+	Start  time.Time     `json:"start"`
+	Count  int           `json:"count"`
+	Levels map[Level]int `json:"levels"`
+}
+
+// CollectStats counts the lines matcher matches within timeRange in buckets of the given size, per level as classify sees it.
+// only lines with a timestamp are counted, the lines of a stack trace are part of the line above them.
+// buckets are aligned to multiples of bucket (since the zero time), in UTC.
+// the lines are read the same way as ReadLastNLinesInRange: backwards from the end of the range
+// (found with a binary search) until the first line older than timeRange.Since.
+// without Since, only the last DEFAULT_STATS_BUCKETS buckets before Until (or now) are counted, not the whole file
+func CollectStats(fileName string, matcher Matcher, timeRange TimeRange, bucket time.Duration,
+	extractTimestamp TimestampExtractor, classify LevelClassifier) (Stats, error) {
+	if bucket <= 0 {
+		return Stats{}, errTooManyBuckets
+	}
+	// buckets too big to go back that far leave the range open
+	if timeRange.Since.IsZero() && bucket <= time.Duration(math.MaxInt64)/DEFAULT_STATS_BUCKETS {
+		end := timeRange.Until
+		if end.IsZero() {
+			end = time.Now()
+		}
+		last := end.Add(-1).UTC().Truncate(bucket)
+		timeRange.Since = last.Add(-bucket * (DEFAULT_STATS_BUCKETS - 1))
+	}
+	if !timeRange.Since.IsZero() && !timeRange.Until.IsZero() &&
+		timeRange.Until.Sub(timeRange.Since)/bucket >= MAX_STATS_BUCKETS {
+		return Stats{}, errTooManyBuckets
+	}
+
+	readChunk, offset, release, err := rangeChunkReader(fileName, timeRange, extractTimestamp)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer release()
+
+	stats := Stats{Levels: map[Level]int{}, Buckets: []StatsBucket{}}
+	buckets := map[time.Time]*StatsBucket{}
+
+	for done := false; !done; {
+		chunk, err := readChunk(offset)
+		if err != nil {
+			return Stats{}, err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+		offset = chunk[len(chunk)-1].Offset
+
+		for _, line := range chunk {
+			timestamp, ok := extractTimestamp(line.Line)
+			if !ok || timeRange.atOrAfterUntil(timestamp) {
+				continue
+			}
+			if timeRange.beforeSince(timestamp) {
+				done = true
+				break
+			}
+			if !isMatchAll(matcher) && !matcher.Match(line.Line) {
+				continue
+			}
+
+			start := timestamp.UTC().Truncate(bucket)
+			current, found := buckets[start]
+			if !found {
+				if len(buckets) >= MAX_STATS_BUCKETS {
+					return Stats{}, errTooManyBuckets
+				}
+				current = &StatsBucket{Start: start, Levels: map[Level]int{}}
+				buckets[start] = current
+			}
+
+			level := classify(line.Line)
+			current.Count++
+			current.Levels[level]++
+			stats.Total++
+			stats.Levels[level]++
+		}
+	}
+
+	// fill in the buckets without lines, over the whole range if it's closed
+	first, last := time.Time{}, time.Time{}
+	for start := range buckets {
+		if first.IsZero() || start.Before(first) {
+			first = start
+		}
+		if last.IsZero() || start.After(last) {
+			last = start
+		}
+	}
+	if !timeRange.Since.IsZero() {
+		first = timeRange.Since.UTC().Truncate(bucket)
+	}
+	if !timeRange.Until.IsZero() {
+		last = timeRange.Until.Add(-1).UTC().Truncate(bucket)
+	}
+	if first.IsZero() || last.IsZero() {
+		return stats, nil
+	}
+	if last.Sub(first)/bucket >= MAX_STATS_BUCKETS {
+		return Stats{}, errTooManyBuckets
+	}
+
+	for start := first; !start.After(last); start = start.Add(bucket) {
+		if current, found := buckets[start]; found {
+			stats.Buckets = append(stats.Buckets, *current)
+			continue
+		}
+		stats.Buckets = append(stats.Buckets, StatsBucket{Start: start, Levels: map[Level]int{}})
+	}
+	return stats, nil
+}
@@ -0,0 +1,74 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+var _ = Describe("CollectStats", func() {
+	start := time.Date(2026, 10, 17, 14, 0, 0, 0, time.UTC)
+	var fileName string
+
+	// a line every 10 seconds for an hour, every 6th an error with a stack trace
+	BeforeEach(func() {
+		var content strings.Builder
+		for i := 0; i < 360; i++ {
+			level := "INFO"
+			if i%6 == 0 {
+				level = "ERROR"
+			}
+			fmt.Fprintf(&content, "%s %s request %d\n", start.Add(time.Duration(i)*10*time.Second).Format(time.RFC3339), level, i)
+			if level == "ERROR" {
+				content.WriteString("  at frame ERROR\n")
+			}
+		}
+		fileName = writeTestFile(content.String())
+	})
+
+	It("counts the lines per bucket and level", func() {
+		timeRange := file.TimeRange{Since: start.Add(10 * time.Minute), Until: start.Add(15 * time.Minute)}
+		stats, err := file.CollectStats(fileName, file.MatchAll, timeRange, time.Minute, file.ExtractTimestamp, file.ClassifyLevel)
+		Expect(err).To(BeNil())
+
+		Expect(stats.Total).To(Equal(30))
+		Expect(stats.Levels).To(Equal(map[file.Level]int{file.LevelInfo: 25, file.LevelError: 5}))
+		Expect(stats.Buckets).To(HaveLen(5))
+		for i, bucket := range stats.Buckets {
+			Expect(bucket.Start).To(Equal(start.Add(time.Duration(10+i) * time.Minute)))
+			Expect(bucket.Count).To(Equal(6))
+			Expect(bucket.Levels[file.LevelError]).To(Equal(1))
+		}
+	})
+
+	It("filters and fills empty buckets", func() {
+		timeRange := file.TimeRange{Since: start.Add(50 * time.Minute), Until: start.Add(70 * time.Minute)}
+		stats, err := file.CollectStats(fileName, file.KeywordMatcher("ERROR"), timeRange, 5*time.Minute, file.ExtractTimestamp, file.ClassifyLevel)
+		Expect(err).To(BeNil())
+
+		Expect(stats.Total).To(Equal(10))
+		counts := []int{}
+		for _, bucket := range stats.Buckets {
+			counts = append(counts, bucket.Count)
+		}
+		Expect(counts).To(Equal([]int{5, 5, 0, 0}))
+	})
+
+	It("counts the last DEFAULT_STATS_BUCKETS buckets without since", func() {
+		timeRange := file.TimeRange{Until: start.Add(time.Hour)}
+		stats, err := file.CollectStats(fileName, file.MatchAll, timeRange, 10*time.Second, file.ExtractTimestamp, file.ClassifyLevel)
+		Expect(err).To(BeNil())
+		Expect(stats.Total).To(Equal(file.DEFAULT_STATS_BUCKETS))
+		Expect(stats.Buckets).To(HaveLen(file.DEFAULT_STATS_BUCKETS))
+		Expect(stats.Buckets[0].Start).To(Equal(start.Add(time.Hour - file.DEFAULT_STATS_BUCKETS*10*time.Second)))
+	})
+
+	It("refuses too many buckets", func() {
+		timeRange := file.TimeRange{Since: start, Until: start.Add(24 * time.Hour)}
+		_, err := file.CollectStats(fileName, file.MatchAll, timeRange, time.Second, file.ExtractTimestamp, file.ClassifyLevel)
+		Expect(err).To(MatchError(file.ErrTooManyBuckets))
+	})
+})
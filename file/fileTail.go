@@ -0,0 +1,175 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// TAIL_POLL_INTERVAL is how often FollowFile checks the file for new content once it has caught up
+const TAIL_POLL_INTERVAL = 500 * time.Millisecond
+
+// FollowFile works like tail -F. it reads fileName forwards starting at position (counted from the beginning of the file)
+// and calls onLine for every complete line that matcher matches, waiting for more lines once it reaches EOF.
+// if the file is truncated, it starts over from the beginning of the file.
+// if the file is rotated (fileName now points to another file), it finishes reading the old one and switches to the new one.
+// returns when ctx is done (with a nil error) or when onLine returns an error.
+// a line that is still being written (no line break yet) is held back until it is complete
+func FollowFile(ctx context.Context, fileName string, position int64, matcher Matcher, onLine func(line string) error) error {
+	file, _, err := openForRead(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	// compressed files are rotated logs, nothing is going to be appended to them
+	if compression, err := detectCompression(file); err != nil {
+		return err
+	} else if compression != CompressionNone {
+		<-ctx.Done()
+		return nil
+	}
+
+	if _, err := file.Seek(position, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReaderSize(file, ReadBufferSize)
+	ticker := time.NewTicker(TAIL_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	// the line being read, without more than MaxLineLength+1 bytes like readForwardLine.
+	// lineLength is how long it is in the file
+	partialLine := []byte{}
+	lineLength := int64(0)
+	emit := func(line []byte, length int64) error {
+		content := truncateLinePrefix(line, length)
+		if isMatchAll(matcher) || matcher.Match(content) {
+			return onLine(content)
+		}
+		return nil
+	}
+
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(partialLine) <= MaxLineLength {
+			partialLine = append(partialLine, chunk...)
+		}
+		position += int64(len(chunk))
+		lineLength += int64(len(chunk))
+
+		switch {
+		case err == nil:
+			if err := emit(bytes.TrimSuffix(partialLine, []byte{'\n'}), lineLength-1); err != nil {
+				return err
+			}
+			partialLine, lineLength = partialLine[:0], 0
+
+		case err == bufio.ErrBufferFull:
+			// the line is longer than the reader's buffer, keep collecting it
+			continue
+
+		case err == io.EOF:
+			current, err := file.Stat()
+			if err != nil {
+				return err
+			}
+
+			// copytruncate, the file has been emptied and is being written from the start again
+			if current.Size() < position {
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					return err
+				}
+				reader.Reset(file)
+				position = 0
+				partialLine, lineLength = partialLine[:0], 0
+				continue
+			}
+
+			// we've read the old file to the end, if fileName points to a new file by now, switch over to it
+			if rotated, err := isRotated(fileName, current); err != nil {
+				return err
+			} else if rotated {
+				// the old file ended without a line break
+				if len(partialLine) > 0 {
+					if err := emit(partialLine, lineLength); err != nil {
+						return err
+					}
+					partialLine, lineLength = partialLine[:0], 0
+				}
+
+				file.Close()
+				if file, _, err = openForRead(fileName); err != nil {
+					return err
+				}
+				reader.Reset(file)
+				position = 0
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+
+		default:
+			return err
+		}
+	}
+}
+
+// isRotated reports whether fileName points to another file than current
+// while the new file hasn't been created yet, it isn't considered rotated
+func isRotated(fileName string, current os.FileInfo) (bool, error) {
+	identity, err := GetFileIdentity(fileName)
+	if errors.Is(err, ErrFileNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return !identity.SameFile(identityFromFileInfo(current)), nil
+}
+
+// CompleteLinesEnd returns the position behind the last line break in front of size (counted from the beginning of the file).
+// the lines of the file that are complete end there, what follows is still being written.
+// a follower starts there, so that it doesn't send the backlog's last line in two halves.
+// compressed files aren't written to anymore, their size is returned
+func CompleteLinesEnd(fileName string, size int64) (int64, error) {
+	if isCompressed(fileName) {
+		return size, nil
+	}
+
+	file, _, err := openReader(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	end := int64(0)
+	err = guardFaults(file, func() error {
+		for chunkEnd := size; chunkEnd > 0; chunkEnd -= int64(ReadBufferSize) {
+			chunkStart := chunkEnd - int64(ReadBufferSize)
+			if chunkStart < 0 {
+				chunkStart = 0
+			}
+
+			chunk, err := file.Slice(chunkStart, chunkEnd)
+			if err != nil {
+				return err
+			}
+			if index := bytes.LastIndexByte(chunk, '\n'); index != -1 {
+				end = chunkStart + int64(index) + 1
+				return nil
+			}
+		}
+		return nil
+	})
+	return end, err
+}
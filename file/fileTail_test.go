@@ -0,0 +1,130 @@
+package file_test
+
+import (
+	"context"
+	"cribl/logmonitor/file"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"os"
+	"strings"
+	"sync"
+)
+
+var _ = Describe("FollowFile", func() {
+	It("delivers lines appended after the start position", func() {
+		fileName := writeTestLines(3)
+		identity, _ := file.GetFileIdentity(fileName)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var mu sync.Mutex
+		lines := []string{}
+		done := make(chan error)
+		go func() {
+			done <- file.FollowFile(ctx, fileName, identity.Size, file.KeywordMatcher("keep"), func(line string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				lines = append(lines, line)
+				return nil
+			})
+		}()
+
+		f, _ := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+		defer f.Close()
+		f.WriteString("keep 1\nskip 2\nkeep 3 is half")
+
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string{}, lines...)
+		}).Should(Equal([]string{"keep 1"}))
+
+		f.WriteString(" written\n")
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string{}, lines...)
+		}).Should(Equal([]string{"keep 1", "keep 3 is half written"}))
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("starts behind the backlog's last complete line, like the stream endpoint", func() {
+		fileName := writeTestFile("one\ntwo\nthr")
+		identity, _ := file.GetFileIdentity(fileName)
+
+		end, err := file.CompleteLinesEnd(fileName, identity.Size)
+		Expect(err).To(BeNil())
+		Expect(end).To(Equal(int64(8)))
+
+		backlog, _, err := file.ReadPage(fileName, 10, file.MatchAll, &file.Cursor{
+			Offset: identity.Size - end, Device: identity.Device, Inode: identity.Inode, Size: identity.Size,
+		})
+		Expect(err).To(BeNil())
+		Expect(backlog).To(Equal([]string{"two", "one"}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var mu sync.Mutex
+		lines := []string{}
+		done := make(chan error)
+		go func() {
+			done <- file.FollowFile(ctx, fileName, end, file.MatchAll, func(line string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				lines = append(lines, line)
+				return nil
+			})
+		}()
+
+		appendToFile(fileName, "ee\n")
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string{}, lines...)
+		}).Should(Equal([]string{"three"}))
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("truncates lines longer than MaxLineLength", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 100
+
+		fileName := writeTestFile("")
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var mu sync.Mutex
+		lines := []string{}
+		done := make(chan error)
+		go func() {
+			done <- file.FollowFile(ctx, fileName, 0, file.MatchAll, func(line string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				lines = append(lines, line)
+				return nil
+			})
+		}()
+
+		appendToFile(fileName, strings.Repeat("x", 30000))
+		appendToFile(fileName, strings.Repeat("x", 20000)+"\nshort\n")
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return append([]string{}, lines...)
+		}).Should(Equal([]string{strings.Repeat("x", 100) + "...[truncated 49900 bytes]", "short"}))
+
+		cancel()
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("returns ErrFileNotFound for a missing file", func() {
+		err := file.FollowFile(context.Background(), "/no/such/file.log", 0, file.MatchAll, func(string) error { return nil })
+		Expect(err).To(MatchError(file.ErrFileNotFound))
+	})
+})
@@ -0,0 +1,236 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+// TimeRange selects the lines written from Since up to (but not including) Until
+// a zero Since or Until leaves that end of the range open
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// IsZero reports whether the range is open on both ends, i.e. selects every line
+func (timeRange TimeRange) IsZero() bool {
+	return timeRange.Since.IsZero() && timeRange.Until.IsZero()
+}
+
+func (timeRange TimeRange) beforeSince(timestamp time.Time) bool {
+	return !timeRange.Since.IsZero() && timestamp.Before(timeRange.Since)
+}
+
+func (timeRange TimeRange) atOrAfterUntil(timestamp time.Time) bool {
+	return !timeRange.Until.IsZero() && !timestamp.Before(timeRange.Until)
+}
+
+// ReadLastNLinesInRange returns the last n lines matcher matches that were written within timeRange, newest first.
+// a line without timestamp (e.g. a line of a stack trace) belongs to the closest line above it that has one,
+// lines in front of the first timestamp of the file are left out.
+// the file is expected to be sorted by time: the end of the range is found with a binary search over the file
+// and the lines are read backwards from there until the first line older than timeRange.Since.
+// compressed files can't be searched, they're read backwards from the end
+func ReadLastNLinesInRange(fileName string, n int, matcher Matcher, timeRange TimeRange, extractTimestamp TimestampExtractor) ([]LineReturn, error) {
+	lines := []LineReturn{}
+	if n <= 0 {
+		return lines, nil
+	}
+
+	readChunk, offset, release, err := rangeChunkReader(fileName, timeRange, extractTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	budget := newResultBudget()
+	// matching lines without timestamp, waiting for the line they belong to.
+	// capped at n and the budget, we never need more
+	withoutTimestamp := []LineReturn{}
+	waiting := newResultBudget()
+	for {
+		chunk, err := readChunk(offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(chunk) == 0 {
+			return lines, nil
+		}
+		offset = chunk[len(chunk)-1].Offset
+
+		for _, line := range chunk {
+			matches := isMatchAll(matcher) || matcher.Match(line.Line)
+
+			timestamp, ok := extractTimestamp(line.Line)
+			if !ok {
+				if matches && len(withoutTimestamp) < n && waiting.take(len(line.Line)) {
+					withoutTimestamp = append(withoutTimestamp, line)
+				}
+				continue
+			}
+
+			if timeRange.beforeSince(timestamp) {
+				return lines, nil
+			}
+			if timeRange.atOrAfterUntil(timestamp) {
+				withoutTimestamp, waiting = withoutTimestamp[:0], newResultBudget()
+				continue
+			}
+
+			if matches {
+				withoutTimestamp = append(withoutTimestamp, line)
+			}
+			for _, line := range withoutTimestamp {
+				if len(lines) == n || !budget.take(len(line.Line)) {
+					return lines, nil
+				}
+				lines = append(lines, line)
+			}
+			withoutTimestamp, waiting = withoutTimestamp[:0], newResultBudget()
+			if len(lines) == n {
+				return lines, nil
+			}
+		}
+	}
+}
+
+// rangeChunkReader returns a function reading the lines in front of a given offset, newest first,
+// and the offset to start reading from for the lines before timeRange.Until.
+// release needs to be called once the reader is no longer used
+func rangeChunkReader(fileName string, timeRange TimeRange, extractTimestamp TimestampExtractor) (
+	readChunk func(fileOffset int64) ([]LineReturn, error), offset int64, release func(), err error) {
+	// compressed files can't be searched
+	if isCompressed(fileName) {
+		readChunk, release, err := lineChunkReader(fileName)
+		return readChunk, 0, release, err
+	}
+
+	file, fileSize, err := openReader(fileName)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	end := fileSize
+	if !timeRange.Until.IsZero() {
+		if end, err = searchTimestamp(file, fileSize, timeRange.Until, extractTimestamp); err != nil {
+			file.Close()
+			return nil, 0, nil, err
+		}
+	}
+
+	// pin the size, offsets stay the same while the file grows
+	readChunk = func(fileOffset int64) ([]LineReturn, error) {
+		return readLastLinesFrom(file, fileSize, fileOffset, ReadBufferSize)
+	}
+	return readChunk, fileSize - end, func() { file.Close() }, nil
+}
+
+// searchTimestamp returns the position (from the start of the file) of the first line
+// with a timestamp at or after until, fileSize if there's none.
+// it bisects the file by byte offset, each probe re-syncs to the next line boundary
+// and reads forward to the first line that has a timestamp
+func searchTimestamp(file Reader, fileSize int64, until time.Time, extractTimestamp TimestampExtractor) (int64, error) {
+	// the line we're looking for starts in [low, high], low is always at a line boundary.
+	// probeHigh shrinks below high while the probes only find lines without timestamp,
+	// those lines may still belong to a line in front of high
+	low, high := int64(0), fileSize
+	probeHigh := high
+	for probeHigh-low > int64(ReadBufferSize) {
+		middle := low + (probeHigh-low)/2
+
+		found := false
+		err := scanLines(file, fileSize, middle, probeHigh, func(start int64, end int64, line string) bool {
+			timestamp, ok := extractTimestamp(line)
+			if !ok {
+				return false
+			}
+
+			found = true
+			if timestamp.Before(until) {
+				low = end
+			} else {
+				high, probeHigh = start, start
+			}
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		if !found {
+			probeHigh = middle
+		}
+	}
+
+	position := high
+	err := scanLines(file, fileSize, low, high, func(start int64, end int64, line string) bool {
+		if timestamp, ok := extractTimestamp(line); ok && !timestamp.Before(until) {
+			position = start
+			return true
+		}
+		return false
+	})
+
+	return position, err
+}
+
+// scanLines calls onLine for the lines starting at from or later and before limit, until it returns true.
+// if from is in the middle of a line, the rest of that line is skipped.
+// start and end are the positions of the line from the start of the file, end includes the line break
+func scanLines(file Reader, fileSize int64, from int64, limit int64, onLine func(start int64, end int64, line string) bool) error {
+	// start looking for the line break in front of from, in case from is already at a line boundary
+	position := int64(0)
+	if from > 0 {
+		position = from - 1
+	}
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, position, fileSize-position), ReadBufferSize)
+
+	skipping := from > 0
+	for position < limit {
+		line, size, err := readForwardLine(reader)
+		if size == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		start := position
+		position += size
+		if skipping {
+			skipping = false
+			continue
+		}
+
+		if onLine(start, position, truncateLine(line)) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// readForwardLine reads the next line from reader, returns the line without line break (up to MaxLineLength+1 bytes)
+// and the number of bytes it takes up in the file
+func readForwardLine(reader *bufio.Reader) ([]byte, int64, error) {
+	line := []byte{}
+	size := int64(0)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		size += int64(len(chunk))
+		if len(line) <= MaxLineLength {
+			line = append(line, chunk...)
+		}
+
+		switch err {
+		case nil:
+			return bytes.TrimSuffix(line, []byte{'\n'}), size, nil
+		case bufio.ErrBufferFull:
+			continue
+		default:
+			return line, size, err
+		}
+	}
+}
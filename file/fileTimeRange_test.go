@@ -0,0 +1,143 @@
+package file_test
+
+import (
+	"cribl/logmonitor/file"
+	"fmt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Timestamp extractors", func() {
+	It("recognizes the supported formats", func() {
+		expected := time.Date(2026, 10, 17, 14, 2, 3, 0, time.UTC)
+
+		for _, line := range []string{
+			"2026-10-17T14:02:03Z GET /api",
+			`127.0.0.1 - frank [17/Oct/2026:14:02:03 +0000] "GET /a.gif HTTP/1.0" 200 2326`,
+			"<34>1 2026-10-17T14:02:03Z mymachine su - ID47 - failed",
+			"1792245723000 started",
+		} {
+			timestamp, ok := file.ExtractTimestamp(line)
+			Expect(ok).To(BeTrue(), line)
+			Expect(timestamp.Equal(expected)).To(BeTrue(), line)
+		}
+
+		timestamp, ok := file.ExtractSyslogTimestamp("Oct  7 14:02:03 mymachine su: failed")
+		Expect(ok).To(BeTrue())
+		Expect(timestamp.Month()).To(Equal(time.October))
+		Expect(timestamp.Day()).To(Equal(7))
+		Expect(timestamp.Location()).To(Equal(time.UTC))
+		Expect(timestamp.After(time.Now().AddDate(0, 0, 1))).To(BeFalse())
+
+		for _, line := range []string{"at com.example.Main(Main.java:10)", "12345 lines", "[error] x", ""} {
+			_, ok := file.ExtractTimestamp(line)
+			Expect(ok).To(BeFalse(), line)
+		}
+
+		_, ok = file.ExtractCLFTimestamp("2026-10-17T14:02:03Z GET /api")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("reads the timestamps without a zone in TimestampLocation", func() {
+		location := time.FixedZone("UTC+2", 2*60*60)
+		defer func(previous *time.Location) { file.TimestampLocation = previous }(file.TimestampLocation)
+		file.TimestampLocation = location
+
+		timestamp, ok := file.ExtractTimestamp("2026-10-17 14:02:03 started")
+		Expect(ok).To(BeTrue())
+		Expect(timestamp.Equal(time.Date(2026, 10, 17, 14, 2, 3, 0, location))).To(BeTrue())
+
+		timestamp, ok = file.ExtractTimestamp("Oct  7 14:02:03 mymachine su: failed")
+		Expect(ok).To(BeTrue())
+		Expect(timestamp.Location()).To(Equal(location))
+		Expect(timestamp.Hour()).To(Equal(14))
+
+		timestamp, ok = file.ExtractTimestamp("2026-10-17T14:02:03Z GET /api")
+		Expect(ok).To(BeTrue())
+		Expect(timestamp.Equal(time.Date(2026, 10, 17, 14, 2, 3, 0, time.UTC))).To(BeTrue())
+	})
+})
+
+var _ = Describe("ReadLastNLinesInRange", func() {
+	start := time.Date(2026, 10, 17, 14, 0, 0, 0, time.UTC)
+	var fileName string
+
+	// a line every second, every 10th line has a stack trace below it
+	BeforeEach(func() {
+		var content strings.Builder
+		for i := 0; i < 5000; i++ {
+			fmt.Fprintf(&content, "%s line %d\n", start.Add(time.Duration(i)*time.Second).Format(time.RFC3339), i)
+			if i%10 == 0 {
+				fmt.Fprintf(&content, "  at frame %d\n  at frame %d\n", i, i)
+			}
+		}
+		fileName = writeTestFile(content.String())
+	})
+
+	read := func(n int, matcher file.Matcher, since time.Time, until time.Time) []string {
+		lines, err := file.ReadLastNLinesInRange(fileName, n, matcher, file.TimeRange{Since: since, Until: until}, file.ExtractTimestamp)
+		Expect(err).To(BeNil())
+
+		strs := []string{}
+		for _, line := range lines {
+			strs = append(strs, line.Line)
+		}
+		return strs
+	}
+
+	It("returns the lines within the range, newest first", func() {
+		lines := read(100, file.MatchAll, start.Add(2000*time.Second), start.Add(2011*time.Second))
+		// 2000 to 2010 and the stack traces of 2000 and 2010
+		Expect(lines).To(HaveLen(11 + 4))
+		Expect(lines[:3]).To(Equal([]string{"  at frame 2010", "  at frame 2010", "2026-10-17T14:33:30Z line 2010"}))
+		Expect(lines[len(lines)-3:]).To(Equal([]string{"  at frame 2000", "  at frame 2000", "2026-10-17T14:33:20Z line 2000"}))
+	})
+
+	It("finds every end of the range", func() {
+		for _, until := range []int{1, 9, 10, 11, 777, 2500, 4999, 5000, 6000} {
+			lines := read(1, file.MatchAll, time.Time{}, start.Add(time.Duration(until)*time.Second))
+			last := until - 1
+			if last > 4999 {
+				last = 4999
+			}
+			if last%10 == 0 {
+				Expect(lines).To(Equal([]string{fmt.Sprintf("  at frame %d", last)}), fmt.Sprint(until))
+			} else {
+				Expect(lines).To(Equal([]string{fmt.Sprintf("%s line %d", start.Add(time.Duration(last)*time.Second).Format(time.RFC3339), last)}), fmt.Sprint(until))
+			}
+		}
+
+		Expect(read(10, file.MatchAll, time.Time{}, start)).To(BeEmpty())
+		Expect(read(10, file.MatchAll, start.Add(time.Hour*2), time.Time{})).To(BeEmpty())
+	})
+
+	It("filters and limits within the range", func() {
+		lines := read(3, file.KeywordMatcher("frame"), start.Add(100*time.Second), start.Add(200*time.Second))
+		Expect(lines).To(Equal([]string{"  at frame 190", "  at frame 190", "  at frame 180"}))
+
+		lines = read(1000, file.KeywordMatcher("line 4"), start.Add(4990*time.Second), time.Time{})
+		Expect(lines).To(Equal([]string{
+			"2026-10-17T15:23:19Z line 4999", "2026-10-17T15:23:18Z line 4998", "2026-10-17T15:23:17Z line 4997",
+			"2026-10-17T15:23:16Z line 4996", "2026-10-17T15:23:15Z line 4995", "2026-10-17T15:23:14Z line 4994",
+			"2026-10-17T15:23:13Z line 4993", "2026-10-17T15:23:12Z line 4992", "2026-10-17T15:23:11Z line 4991",
+			"2026-10-17T15:23:10Z line 4990",
+		}))
+	})
+
+	It("reads compressed files", func() {
+		content, err := file.ReadLastNLinesWithKeyword(fileName, 100000, "")
+		Expect(err).To(BeNil())
+		for i, j := 0, len(content)-1; i < j; i, j = i+1, j-1 {
+			content[i], content[j] = content[j], content[i]
+		}
+		plain := fileName
+		fileName = plain + ".1.gz"
+		writeGzipFile(fileName, strings.Join(content, "\n")+"\n")
+
+		lines := read(100, file.MatchAll, start.Add(2000*time.Second), start.Add(2011*time.Second))
+		Expect(lines).To(HaveLen(15))
+		Expect(lines[2]).To(Equal("2026-10-17T14:33:30Z line 2010"))
+	})
+})
@@ -0,0 +1,137 @@
+package file
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimestampExtractor finds the time a log line was written, ok is false if the line doesn't have a timestamp
+type TimestampExtractor func(line string) (timestamp time.Time, ok bool)
+
+// TimestampExtractors are the extractors the api can pick by name
+var TimestampExtractors = map[string]TimestampExtractor{
+	"auto":     ExtractTimestamp,
+	"rfc3339":  ExtractRFC3339Timestamp,
+	"syslog":   ExtractSyslogTimestamp,
+	"clf":      ExtractCLFTimestamp,
+	"epoch_ms": ExtractEpochMillisTimestamp,
+}
+
+// TimestampLocation is the time zone of the timestamps that don't have one, like the RFC 3164 syslog ones
+var TimestampLocation = time.UTC
+
+// timestampLayouts are tried at the start of a line, in order
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999 -0700 MST", // time.Time.String(), what WriteFileHelper writes
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// ExtractTimestamp is the default TimestampExtractor
+// it tries all of the extractors below, RFC3339 first
+func ExtractTimestamp(line string) (time.Time, bool) {
+	for _, extract := range []TimestampExtractor{
+		ExtractRFC3339Timestamp, ExtractSyslogTimestamp, ExtractCLFTimestamp, ExtractEpochMillisTimestamp,
+	} {
+		if timestamp, ok := extract(line); ok {
+			return timestamp, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// ExtractRFC3339Timestamp recognizes RFC3339 and similar timestamps at the beginning of the line
+func ExtractRFC3339Timestamp(line string) (time.Time, bool) {
+	for _, layout := range timestampLayouts {
+		// the timestamp has as many fields as the layout, the rest of the line is the message
+		fields := strings.Count(layout, " ") + 1
+		candidate := line
+		if parts := strings.SplitN(line, " ", fields+1); len(parts) > fields {
+			candidate = strings.Join(parts[:fields], " ")
+		}
+
+		if timestamp, err := time.ParseInLocation(layout, candidate, TimestampLocation); err == nil {
+			return timestamp, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// ExtractSyslogTimestamp recognizes the timestamps of syslog lines
+//
+//	Oct 11 22:14:15 mymachine su: ...                       RFC 3164, in TimestampLocation
+//	<34>1 2003-10-11T22:14:15.003Z mymachine su - ID47 ...  RFC 5424
+//
+// RFC 3164 timestamps have no year, it's the current one unless that puts the line in the future
+func ExtractSyslogTimestamp(line string) (time.Time, bool) {
+	// the priority is usually gone once the line is written to a file
+	if strings.HasPrefix(line, "<") {
+		if end := strings.IndexByte(line, '>'); end > 0 {
+			line = line[end+1:]
+		}
+	}
+
+	// RFC 5424 has a version in front of the timestamp
+	if len(line) > 2 && line[0] >= '1' && line[0] <= '9' && line[1] == ' ' {
+		return ExtractRFC3339Timestamp(line[2:])
+	}
+
+	if len(line) < len(time.Stamp) {
+		return time.Time{}, false
+	}
+	timestamp, err := time.ParseInLocation(time.Stamp, line[:len(time.Stamp)], TimestampLocation)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	timestamp = timestamp.AddDate(now.Year(), 0, 0)
+	if timestamp.After(now.AddDate(0, 0, 1)) {
+		// written in december, read in january
+		timestamp = timestamp.AddDate(-1, 0, 0)
+	}
+
+	return timestamp, true
+}
+
+// CLF_TIMESTAMP_LAYOUT is the timestamp of the apache/nginx common (and combined) log format
+// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+const CLF_TIMESTAMP_LAYOUT = "02/Jan/2006:15:04:05 -0700"
+
+// ExtractCLFTimestamp recognizes the bracketed timestamp of the common log format
+func ExtractCLFTimestamp(line string) (time.Time, bool) {
+	start := strings.IndexByte(line, '[')
+	if start == -1 || len(line) < start+len(CLF_TIMESTAMP_LAYOUT)+2 || line[start+len(CLF_TIMESTAMP_LAYOUT)+1] != ']' {
+		return time.Time{}, false
+	}
+
+	timestamp, err := time.Parse(CLF_TIMESTAMP_LAYOUT, line[start+1:start+len(CLF_TIMESTAMP_LAYOUT)+1])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return timestamp, true
+}
+
+// ExtractEpochMillisTimestamp recognizes milliseconds since the unix epoch (13 digits) at the beginning of the line
+func ExtractEpochMillisTimestamp(line string) (time.Time, bool) {
+	digits := 0
+	for digits < len(line) && line[digits] >= '0' && line[digits] <= '9' {
+		digits++
+	}
+	if digits != 13 {
+		return time.Time{}, false
+	}
+
+	millis, err := strconv.ParseInt(line[:digits], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.UnixMilli(millis), true
+}
@@ -1,6 +1,10 @@
 package file_test
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -11,3 +15,23 @@ func TestFile(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "File Suite")
 }
+
+// writeTestLines writes "Line 1 ..." to "Line n ..." into a temp file that is removed after the spec
+func writeTestLines(n int) string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("Line %d of the test file", i+1)
+	}
+	return writeTestFile(strings.Join(lines, "\n") + "\n")
+}
+
+// writeTestFile writes content into a temp file that is removed after the spec
+func writeTestFile(content string) string {
+	dir, err := os.MkdirTemp("", "logmonitor")
+	Expect(err).To(BeNil())
+	DeferCleanup(os.RemoveAll, dir)
+
+	fileName := filepath.Join(dir, "test.log")
+	Expect(os.WriteFile(fileName, []byte(content), 0644)).To(Succeed())
+	return fileName
+}
@@ -2,8 +2,10 @@ package file_test
 
 import (
 	"cribl/logmonitor/file"
+	"errors"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"strings"
 )
 
 var _ = Describe("ReadLastLinesWithOffset", func() {
@@ -254,3 +256,65 @@ var _ = Describe("ReadLastNLinesWithKeyword", func() {
 		Expect(err).To(BeNil())
 	})
 })
+
+var _ = Describe("ReadLastLinesWithOffset errors", func() {
+	It("returns ErrFileNotFound for a missing file", func() {
+		_, _, err := file.ReadLastLinesWithOffset("/no/such/file.log", 0, 128)
+		Expect(errors.Is(err, file.ErrFileNotFound)).To(BeTrue())
+
+		_, err = file.ReadLastNLinesWithKeyword("/no/such/file.log", 10, "")
+		Expect(errors.Is(err, file.ErrFileNotFound)).To(BeTrue())
+	})
+
+	It("returns ErrOffsetNotAtLineBoundary when the offset is in the middle of a line", func() {
+		fileName := writeTestLines(10)
+
+		_, _, err := file.ReadLastLinesWithOffset(fileName, 3, 128)
+		Expect(err).To(Equal(file.ErrOffsetNotAtLineBoundary))
+	})
+
+})
+
+var _ = Describe("ReadLastLinesWithOffset long lines", func() {
+	It("grows the buffer for lines longer than the buffer", func() {
+		longLine := strings.Repeat("x", 1000)
+		fileName := writeTestFile("first\n" + longLine + "\nlast\n")
+
+		lines, offset, err := file.ReadLastLinesWithOffset(fileName, 0, 128)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"last"}))
+
+		lines, offset, err = file.ReadLastLinesWithOffset(fileName, offset, 128)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{longLine, "first"}))
+		Expect(offset).To(Equal(int64(1 + 1000 + 1 + 4 + 1 + 5)))
+	})
+
+	It("truncates lines longer than MaxLineLength", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 300
+
+		fileName := writeTestFile("first\n" + strings.Repeat("x", 1000) + "\nlast\n")
+		truncated := strings.Repeat("x", 300) + "...[truncated 700 bytes]"
+
+		lines, err := file.ReadLastNLinesWithKeyword(fileName, 10, "")
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{"last", truncated, "first"}))
+
+		paginated, _, err := file.ReadLastNLinesWithKeywordPaginationInternal(fileName, 10, "", 0, 64)
+		Expect(err).To(BeNil())
+		Expect(paginated).To(Equal([]string{"last", truncated, "first"}))
+	})
+
+	It("truncates lines longer than MaxLineLength at the beginning of the file", func() {
+		defer func(max int) { file.MaxLineLength = max }(file.MaxLineLength)
+		file.MaxLineLength = 100
+
+		fileName := writeTestFile(strings.Repeat("y", 50000) + "\nlast\n")
+
+		lines, offset, err := file.ReadLastLinesWithOffset(fileName, 5, 64)
+		Expect(err).To(BeNil())
+		Expect(lines).To(Equal([]string{strings.Repeat("y", 100) + "...[truncated 49900 bytes]"}))
+		Expect(offset).To(Equal(int64(50006)))
+	})
+})
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"cribl/logmonitor/file"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"time"
+)
+
+type indexResponse struct {
+	Files []indexStatus `json:"files"`
+}
+
+type indexStatus struct {
+	Name string `json:"name"`
+	file.IndexStatus
+}
+
+// indexKeywords keeps the keyword indexes of the keyword_index.files up to date, it never returns.
+// the config is read again every run, a reload changes the files and the interval
+func indexKeywords() {
+	for {
+		config := currentConfig()
+		for _, fileName := range keywordIndexedFiles(config) {
+			if err := file.BuildKeywordIndex(fileName); err != nil {
+				log.Printf("keyword index: %s: %v", config.Roots.Relative(fileName), err)
+			}
+		}
+
+		time.Sleep(time.Duration(config.KeywordIndex.IntervalSeconds) * time.Second)
+	}
+}
+
+// keywordIndexedFiles are the files matching keyword_index.files in all roots, each one once
+func keywordIndexedFiles(config *serverConfig) []string {
+	seen := map[string]bool{}
+	fileNames := []string{}
+	for _, pattern := range config.KeywordIndex.Files {
+		matches, err := config.Roots.Glob(pattern)
+		if err != nil {
+			log.Printf("keyword index: %s: %v", pattern, err)
+			continue
+		}
+
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				fileNames = append(fileNames, match)
+			}
+		}
+	}
+	return fileNames
+}
+
+// indexStatuses handles /api/v1/index, how far the line and keyword indexes of the files have got.
+// filename works like for /api/v1/logs, without it the files of keyword_index.files are listed
+func indexStatuses(c *gin.Context) {
+	config := currentConfig()
+	fileNames := keywordIndexedFiles(config)
+	if _, found := c.GetQuery("filename"); found {
+		var err error
+		if fileNames, _, err = filenamesFromQuery(c); err != nil {
+			abortWithError(c, err)
+			return
+		}
+	}
+
+	response := indexResponse{Files: []indexStatus{}}
+	for _, fileName := range fileNames {
+		status, err := file.FileIndexStatus(fileName)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+		response.Files = append(response.Files, indexStatus{Name: config.Roots.Relative(fileName), IndexStatus: status})
+	}
+
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.IndentedJSON(http.StatusOK, response)
+}
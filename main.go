@@ -2,62 +2,560 @@ package main
 
 import (
 	"cribl/logmonitor/file"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 )
 
 const FILE_PATH = "/var/log/"
 
+// levelLine is a line of the response with level=
+type levelLine struct {
+	Line  string     `json:"line"`
+	Level file.Level `json:"level"`
+}
+
+// linesResponse shapes the lines of a response: plain strings, parsed with format=, or with their level with level=
+func linesResponse(lines []string, format file.Format, parse bool, withLevel bool) any {
+	if parse {
+		parsed := file.ParseLines(lines, format)
+		if withLevel {
+			// the same names as without format, unknown included
+			for i := range parsed {
+				parsed[i].Level = file.ClassifyLevel(parsed[i].Line).String()
+			}
+		}
+		return parsed
+	}
+
+	if withLevel {
+		leveled := make([]levelLine, len(lines))
+		for i, line := range lines {
+			leveled[i] = levelLine{Line: line, Level: file.ClassifyLevel(line)}
+		}
+		return leveled
+	}
+
+	return lines
+}
+
+// mergedParsedLine is a line of several files parsed with format=, the timestamp is the parsed one
+type mergedParsedLine struct {
+	file.ParsedLine
+	Source string `json:"source"`
+	Offset int64  `json:"offset"`
+}
+
+// mergedLevelLine is a line of several files with level=
+type mergedLevelLine struct {
+	file.MergedLine
+	Level file.Level `json:"level"`
+}
+
+// mergedLinesResponse shapes the lines of several files like linesResponse, keeping their source and offset
+func mergedLinesResponse(lines []file.MergedLine, format file.Format, parse bool, withLevel bool) any {
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Line
+	}
+
+	switch shaped := linesResponse(texts, format, parse, withLevel).(type) {
+	case []file.ParsedLine:
+		parsed := make([]mergedParsedLine, len(lines))
+		for i, line := range lines {
+			parsed[i] = mergedParsedLine{ParsedLine: shaped[i], Source: line.Source, Offset: line.Offset}
+		}
+		return parsed
+	case []levelLine:
+		leveled := make([]mergedLevelLine, len(lines))
+		for i, line := range lines {
+			leveled[i] = mergedLevelLine{MergedLine: line, Level: shaped[i].Level}
+		}
+		return leveled
+	}
+
+	return lines
+}
+
+// contextParsedLine is a line of a match group parsed with format=
+type contextParsedLine struct {
+	file.ParsedLine
+	Offset int64 `json:"offset"`
+	Match  bool  `json:"match"`
+}
+
+// contextLevelLine is a line of a match group with level=
+type contextLevelLine struct {
+	file.ContextLine
+	Level file.Level `json:"level"`
+}
+
+// shapedGroup is a file.MatchGroup with its lines shaped by groupsResponse
+type shapedGroup struct {
+	Lines any `json:"lines"`
+}
+
+// groupsResponse shapes the lines of match groups like linesResponse, keeping their offset and whether they match
+func groupsResponse(groups []file.MatchGroup, format file.Format, parse bool, withLevel bool) any {
+	if !parse && !withLevel {
+		return groups
+	}
+
+	shapedGroups := make([]shapedGroup, len(groups))
+	for i, group := range groups {
+		texts := make([]string, len(group.Lines))
+		for j, line := range group.Lines {
+			texts[j] = line.Line
+		}
+
+		switch shaped := linesResponse(texts, format, parse, withLevel).(type) {
+		case []file.ParsedLine:
+			parsed := make([]contextParsedLine, len(group.Lines))
+			for j, line := range group.Lines {
+				parsed[j] = contextParsedLine{ParsedLine: shaped[j], Offset: line.Offset, Match: line.Match}
+			}
+			shapedGroups[i].Lines = parsed
+		case []levelLine:
+			leveled := make([]contextLevelLine, len(group.Lines))
+			for j, line := range group.Lines {
+				leveled[j] = contextLevelLine{ContextLine: line, Level: shaped[j].Level}
+			}
+			shapedGroups[i].Lines = leveled
+		default:
+			shapedGroups[i].Lines = group.Lines
+		}
+	}
+	return shapedGroups
+}
+
+type filesResponse struct {
+	Files []file.FileInfo `json:"files"`
+	Total int             `json:"total"`
+}
+
+type pageResponse struct {
+	// Lines are shaped by linesResponse
+	Lines any `json:"lines"`
+	// Offsets are the offsets of the lines of the file itself (not of its rotated copies), counted back from FileSize.
+	// they go into from_offset and file_size
+	Offsets    []int64 `json:"offsets"`
+	FileSize   int64   `json:"file_size"`
+	NextCursor string  `json:"next_cursor"`
+	HasMore    bool    `json:"has_more"`
+}
+
+// splitLines separates the lines from their offsets
+func splitLines(lines []file.LineReturn) ([]string, []int64) {
+	texts := make([]string, len(lines))
+	offsets := make([]int64, len(lines))
+	for i, line := range lines {
+		texts[i] = line.Line
+		offsets[i] = line.Offset
+	}
+	return texts, offsets
+}
+
 func main() {
-	router := gin.Default()
+	config, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	config.applyLimits()
+	config.applyMaxSizes()
+	activeConfig.Store(config)
+	go reloadOnHangup(os.Args[1:])
+	if config.KeywordIndex.Dir != "" {
+		go indexKeywords()
+	}
+
+	router := gin.New()
+	router.Use(requestLogger(), gin.Recovery(), requireToken)
 
 	router.GET("/api/v1/logs", func(c *gin.Context) {
-		size := c.DefaultQuery("size", "100")
-		filename := c.DefaultQuery("filename", "var5MB.txt")
-		searchKeyword := c.Query("keyword")
+		numOfEntries, err := sizeFromQuery(c, "size", currentConfig().DefaultSize, "logs")
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
 
-		numOfEntries, err := strconv.Atoi(size)
+		matcher, withLevel, err := matcherFromQuery(c)
 		if err != nil {
-			c.Error(err)
+			abortWithError(c, err)
+			return
 		}
 
-		filenameWithPath := FILE_PATH + filename
+		before, after, withContext, err := contextFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		skip, err := skipFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		timeRange, extractTimestamp, err := timeRangeFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		format, parse, err := formatFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
 
-		result, err := file.ReadLastNLinesWithKeyword(filenameWithPath, numOfEntries, searchKeyword)
+		filenames, multiple, err := filenamesFromQuery(c)
 		if err != nil {
-			c.Error(err)
+			abortWithError(c, err)
+			return
+		}
+
+		if multiple && withContext {
+			abortWithError(c, fmt.Errorf("%w: context lines are only available for a single file", errInvalidParameter))
+			return
+		}
+		if !timeRange.IsZero() && (multiple || withContext) {
+			abortWithError(c, fmt.Errorf("%w: since and until are only available for a single file without context", errInvalidParameter))
+			return
+		}
+		if skip > 0 && (multiple || withContext || !timeRange.IsZero()) {
+			abortWithError(c, fmt.Errorf("%w: skip is only available for a single file without context or time range", errInvalidParameter))
+			return
+		}
+
+		if multiple {
+			lines, err := file.ReadLastNLinesMerged(filenames, numOfEntries, matcher, extractTimestamp)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			for i := range lines {
+				lines[i].Source = currentConfig().Roots.Relative(lines[i].Source)
+			}
+
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			c.IndentedJSON(http.StatusOK, mergedLinesResponse(lines, format, parse, withLevel))
+			return
+		}
+
+		filenameWithPath := filenames[0]
+
+		if withContext {
+			groups, err := file.ReadLastNMatchesWithContext(filenameWithPath, numOfEntries, matcher, before, after)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			c.IndentedJSON(http.StatusOK, groupsResponse(groups, format, parse, withLevel))
+			return
+		}
+
+		if !timeRange.IsZero() {
+			lines, err := file.ReadLastNLinesInRange(filenameWithPath, numOfEntries, matcher, timeRange, extractTimestamp)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			result := make([]string, len(lines))
+			for i, line := range lines {
+				result[i] = line.Line
+			}
+
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			c.IndentedJSON(http.StatusOK, linesResponse(result, format, parse, withLevel))
+			return
+		}
+
+		if skip > 0 {
+			result, err := file.ReadLastNLinesMatchingSkipping(filenameWithPath, numOfEntries, matcher, skip)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			c.IndentedJSON(http.StatusOK, linesResponse(result, format, parse, withLevel))
+			return
+		}
+
+		result, err := file.ReadLastNLinesMatching(filenameWithPath, numOfEntries, matcher)
+		if err != nil {
+			abortWithError(c, err)
+			return
 		}
 
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.IndentedJSON(http.StatusOK, result)
+		c.IndentedJSON(http.StatusOK, linesResponse(result, format, parse, withLevel))
 	})
 
 	router.GET("/api/v1/plogs", func(c *gin.Context) {
-		size := c.DefaultQuery("size", "100")
-		filename := c.DefaultQuery("filename", "var5MB.txt")
-		searchKeyword := c.Query("keyword")
+		numOfEntries, err := sizeFromQuery(c, "size", currentConfig().DefaultSize, "plogs")
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
 
-		numOfEntries, err := strconv.Atoi(size)
+		matcher, _, err := matcherFromQuery(c)
 		if err != nil {
-			c.Error(err)
+			abortWithError(c, err)
+			return
 		}
 
-		filenameWithPath := FILE_PATH + filename
+		filenameWithPath, err := resolveFilename(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
 
-		result, err := file.ReadLastNLinesWithKeywordP(filenameWithPath, numOfEntries, searchKeyword)
+		result, err := file.ReadLastNLinesMatchingP(filenameWithPath, numOfEntries, matcher)
 		if err != nil {
-			c.Error(err)
+			abortWithError(c, err)
+			return
 		}
 
 		retVal := make([]string, len(result))
 		for i, r := range result {
 			// strip line break at the end so that response is human readable
-			retVal[i] = string(r[:len(r)-1])
+			retVal[i] = string(file.TrimLineBreak(r))
 		}
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.IndentedJSON(http.StatusOK, retVal)
 	})
 
-	router.Run("localhost:8080")
+	router.GET("/api/v1/logs/page", func(c *gin.Context) {
+		secret := currentConfig().secret
+		token := c.Query("cursor")
+
+		numOfEntries, err := sizeFromQuery(c, "size", currentConfig().DefaultSize, "page")
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		matcher, withLevel, err := matcherFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		format, parse, err := formatFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		ascending, fromOffset, fromSize, withOffset, err := orderFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		filenameWithPath, err := resolveFilename(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		var cursor *file.Cursor
+		if token != "" {
+			if withOffset {
+				abortWithError(c, fmt.Errorf("%w: from_offset and cursor can't be combined", errInvalidParameter))
+				return
+			}
+
+			decoded, err := file.DecodeCursor(token, secret)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+			cursor = &decoded
+		}
+
+		if ascending {
+			// the first page starts at from_offset, or at the start of the file
+			if cursor == nil {
+				identity, err := file.GetFileIdentity(filenameWithPath)
+				if err != nil {
+					abortWithError(c, err)
+					return
+				}
+				cursor = &file.Cursor{
+					Offset:  identity.Size,
+					Device:  identity.Device,
+					Inode:   identity.Inode,
+					Size:    identity.Size,
+					Forward: true,
+				}
+				// counted back from file_size, the file might have grown since the offset was handed out
+				if withOffset {
+					cursor.Offset = fromOffset
+					if fromSize != -1 {
+						cursor.Size = fromSize
+					}
+				}
+			}
+
+			lines, next, hasMore, err := file.ReadPageForward(filenameWithPath, numOfEntries, matcher, *cursor)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			texts, offsets := splitLines(lines)
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			c.IndentedJSON(http.StatusOK, pageResponse{
+				Lines:      linesResponse(texts, format, parse, withLevel),
+				Offsets:    offsets,
+				FileSize:   next.Size,
+				NextCursor: file.EncodeCursor(*next, secret),
+				HasMore:    hasMore,
+			})
+			return
+		}
+
+		lines, own, fileSize, next, err := file.ReadPageWithOffsets(filenameWithPath, numOfEntries, matcher, cursor)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		texts, offsets := splitLines(lines)
+		response := pageResponse{
+			Lines:    linesResponse(texts, format, parse, withLevel),
+			Offsets:  offsets[:own],
+			FileSize: fileSize,
+			HasMore:  next != nil,
+		}
+		if next != nil {
+			response.NextCursor = file.EncodeCursor(*next, secret)
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.IndentedJSON(http.StatusOK, response)
+	})
+
+	router.GET(STREAM_PATH, streamLogs)
+
+	router.GET("/api/v1/logs/patterns", func(c *gin.Context) {
+		numOfEntries, err := sizeFromQuery(c, "size", 10000, "patterns")
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		top, err := strconv.Atoi(c.DefaultQuery("top", "20"))
+		if err != nil || top < 0 {
+			abortWithError(c, fmt.Errorf("%w: top must be a non negative number", errInvalidParameter))
+			return
+		}
+
+		matcher, _, err := matcherFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		filenameWithPath, err := resolveFilename(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		clusters, err := file.ClusterLastNLines(filenameWithPath, numOfEntries, top, matcher)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.IndentedJSON(http.StatusOK, clusters)
+	})
+
+	router.GET("/api/v1/logs/stats", func(c *gin.Context) {
+		bucket, err := time.ParseDuration(c.DefaultQuery("bucket", "1m"))
+		if err != nil || bucket <= 0 {
+			abortWithError(c, fmt.Errorf("%w: bucket must be a positive duration like 1m", errInvalidParameter))
+			return
+		}
+
+		matcher, _, err := matcherFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		timeRange, extractTimestamp, err := timeRangeFromQuery(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		filenameWithPath, err := resolveFilename(c)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		stats, err := file.CollectStats(filenameWithPath, matcher, timeRange, bucket, extractTimestamp, file.ClassifyLevel)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.IndentedJSON(http.StatusOK, stats)
+	})
+
+	router.GET("/api/v1/files", func(c *gin.Context) {
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			abortWithError(c, fmt.Errorf("%w: offset must be a non negative number", errInvalidParameter))
+			return
+		}
+
+		// the default is capped at max_size.files like size, 0 would list all files
+		limit, err := sizeFromQuery(c, "limit", 100, "files")
+		if err == nil && limit == 0 {
+			err = fmt.Errorf("%w: limit must be a number between 1 and %d", errInvalidParameter, currentConfig().maxSize("files"))
+		}
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		files, total, err := currentConfig().Roots.ListFiles(file.ListOptions{Name: c.Query("name"), Offset: offset, Limit: limit})
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+		c.IndentedJSON(http.StatusOK, filesResponse{Files: files, Total: total})
+	})
+
+	router.GET("/api/v1/index", indexStatuses)
+
+	if config.TLS.CertFile != "" {
+		err = router.RunTLS(config.Listen, config.TLS.CertFile, config.TLS.KeyFile)
+	} else {
+		err = router.Run(config.Listen)
+	}
+	log.Fatal(err)
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"cribl/logmonitor/file"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// matcherFromQuery builds the line filter out of the query params
+//
+//	keyword=error&keyword=-timeout  lines containing error but not timeout (keyword can be repeated)
+//	re=5\d\d                        lines matching the regular expression (re can be repeated)
+//	ci=true                         case insensitive keywords and regular expressions
+//	op=or                           lines matching any instead of all of the terms above
+//	q=level:error AND status>=500   lines matching a query on the parsed fields (see file.ParseQuery), on top of the terms above.
+//	                                lines are parsed in format, auto by default
+//	level=warn                      lines of this severity or above (see file.ClassifyLevel)
+//
+// withLevel tells whether level is set, the response then has the level of each line
+func matcherFromQuery(c *gin.Context) (matcher file.Matcher, withLevel bool, err error) {
+	caseInsensitive := false
+	if ci := c.Query("ci"); ci != "" {
+		if caseInsensitive, err = strconv.ParseBool(ci); err != nil {
+			return nil, false, fmt.Errorf("%w: ci: %v", errInvalidParameter, err)
+		}
+	}
+
+	matchAny := false
+	switch op := c.DefaultQuery("op", "and"); op {
+	case "and":
+	case "or":
+		matchAny = true
+	default:
+		return nil, false, fmt.Errorf("%w: op must be and or or, got %q", errInvalidParameter, op)
+	}
+
+	matcher, err = file.NewMatcher(file.MatcherOptions{
+		Keywords:        c.QueryArray("keyword"),
+		Regexes:         c.QueryArray("re"),
+		CaseInsensitive: caseInsensitive,
+		Any:             matchAny,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	minimum, withLevel, err := levelFromQuery(c)
+	if err != nil {
+		return nil, false, err
+	}
+	matcher = file.And(matcher, file.LevelMatcher(minimum, file.ClassifyLevel))
+
+	query := c.Query("q")
+	if query == "" {
+		return matcher, withLevel, nil
+	}
+
+	format, ok, err := formatFromQuery(c)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		format = file.FormatAuto
+	}
+
+	queryMatcher, err := file.NewQueryMatcher(query, format)
+	if err != nil {
+		return nil, false, err
+	}
+	return file.And(matcher, queryMatcher), withLevel, nil
+}
+
+// sizeFromQuery reads a line count param like size, fallback if it isn't set.
+// it has to be between 0 and the max_size of endpoint, a bigger fallback is cut down to it
+func sizeFromQuery(c *gin.Context, name string, fallback int, endpoint string) (int, error) {
+	maximum := currentConfig().maxSize(endpoint)
+	value, found := c.GetQuery(name)
+	if !found {
+		if fallback > maximum {
+			return maximum, nil
+		}
+		return fallback, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 || number > maximum {
+		return 0, fmt.Errorf("%w: %s must be a number between 0 and %d, got %q", errInvalidParameter, name, maximum, value)
+	}
+	return number, nil
+}
+
+// skipFromQuery reads skip, the number of the newest lines of the file to leave out (before filtering)
+func skipFromQuery(c *gin.Context) (int, error) {
+	skip, err := strconv.Atoi(c.DefaultQuery("skip", "0"))
+	if err != nil || skip < 0 {
+		return 0, fmt.Errorf("%w: skip must be a non negative number, got %q", errInvalidParameter, c.Query("skip"))
+	}
+	return skip, nil
+}
+
+// orderFromQuery reads the order of a page
+//
+//	order=desc           newest first (default)
+//	order=asc            oldest first, from the start of the file
+//	from_offset=1024     with order=asc, from the line at this offset (as in the offsets of a page) on
+//	file_size=52428800   the file_size of the page from_offset comes from, the current size of the file by default
+//
+// found is false without from_offset, fileSize is -1 without file_size
+func orderFromQuery(c *gin.Context) (ascending bool, fromOffset int64, fileSize int64, found bool, err error) {
+	switch order := c.DefaultQuery("order", "desc"); order {
+	case "desc":
+	case "asc":
+		ascending = true
+	default:
+		return false, 0, 0, false, fmt.Errorf("%w: order must be asc or desc, got %q", errInvalidParameter, order)
+	}
+
+	value, found := c.GetQuery("from_offset")
+	if !found {
+		if _, sized := c.GetQuery("file_size"); sized {
+			return false, 0, 0, false, fmt.Errorf("%w: file_size needs from_offset", errInvalidParameter)
+		}
+		return ascending, 0, 0, false, nil
+	}
+	if !ascending {
+		return false, 0, 0, false, fmt.Errorf("%w: from_offset needs order=asc", errInvalidParameter)
+	}
+	fromOffset, err = strconv.ParseInt(value, 10, 64)
+	if err != nil || fromOffset < 0 {
+		return false, 0, 0, false, fmt.Errorf("%w: from_offset must be a non negative number, got %q", errInvalidParameter, value)
+	}
+
+	fileSize = -1
+	if value, sized := c.GetQuery("file_size"); sized {
+		fileSize, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || fileSize < fromOffset {
+			return false, 0, 0, false, fmt.Errorf("%w: file_size must be a number no less than from_offset, got %q", errInvalidParameter, value)
+		}
+	}
+	return ascending, fromOffset, fileSize, true, nil
+}
+
+// contextFromQuery reads the grep style context params
+//
+//	before=5  5 lines in front of each match
+//	after=5   5 lines behind each match
+//	context=5 both of the above, before and after take precedence
+//
+// ok is false when none of them are set
+func contextFromQuery(c *gin.Context) (before int, after int, ok bool, err error) {
+	parse := func(name string, fallback int) (int, error) {
+		value, found := c.GetQuery(name)
+		if !found {
+			return fallback, nil
+		}
+
+		ok = true
+		maximum := currentConfig().MaxContext
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 || number > maximum {
+			return 0, fmt.Errorf("%w: %s must be a number between 0 and %d, got %q", errInvalidParameter, name, maximum, value)
+		}
+		return number, nil
+	}
+
+	context, err := parse("context", 0)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	if before, err = parse("before", context); err != nil {
+		return 0, 0, false, err
+	}
+	if after, err = parse("after", context); err != nil {
+		return 0, 0, false, err
+	}
+
+	return before, after, ok, nil
+}
+
+// timeRangeFromQuery reads the time range params
+//
+//	since=2026-10-17T14:02:00Z  lines written at or after since
+//	until=1792245720000         lines written before until
+//	ts=syslog                   how to find the timestamp of a line, auto (default), rfc3339, syslog, clf or epoch_ms
+//
+// since and until are RFC3339 timestamps or milliseconds since the epoch
+func timeRangeFromQuery(c *gin.Context) (file.TimeRange, file.TimestampExtractor, error) {
+	name := c.DefaultQuery("ts", "auto")
+	extractTimestamp, found := file.TimestampExtractors[name]
+	if !found {
+		return file.TimeRange{}, nil, fmt.Errorf("%w: unknown ts %q", errInvalidParameter, name)
+	}
+
+	parse := func(name string) (time.Time, error) {
+		value := c.Query(name)
+		if value == "" {
+			return time.Time{}, nil
+		}
+
+		if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.UnixMilli(millis), nil
+		}
+		timestamp, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: %s must be an RFC3339 timestamp or epoch milliseconds, got %q", errInvalidParameter, name, value)
+		}
+		return timestamp, nil
+	}
+
+	since, err := parse("since")
+	if err != nil {
+		return file.TimeRange{}, nil, err
+	}
+	until, err := parse("until")
+	if err != nil {
+		return file.TimeRange{}, nil, err
+	}
+	if !since.IsZero() && !until.IsZero() && !since.Before(until) {
+		return file.TimeRange{}, nil, fmt.Errorf("%w: since needs to be before until", errInvalidParameter)
+	}
+
+	return file.TimeRange{Since: since, Until: until}, extractTimestamp, nil
+}
+
+// levelFromQuery reads the minimum severity level=warn, ok is false without it
+func levelFromQuery(c *gin.Context) (level file.Level, ok bool, err error) {
+	value := c.Query("level")
+	if value == "" {
+		return file.LevelUnknown, false, nil
+	}
+
+	level, ok = file.ParseLevel(value)
+	if !ok {
+		return file.LevelUnknown, false, fmt.Errorf("%w: unknown level %q", errInvalidParameter, value)
+	}
+	return level, true, nil
+}
+
+// formatFromQuery reads format=json|logfmt|syslog|clf|auto, ok is false without it (lines are returned as plain strings)
+func formatFromQuery(c *gin.Context) (format file.Format, ok bool, err error) {
+	value := c.Query("format")
+	switch format := file.Format(value); format {
+	case "":
+		return "", false, nil
+	case file.FormatJSON, file.FormatLogfmt, file.FormatSyslog, file.FormatCLF, file.FormatAuto:
+		return format, true, nil
+	default:
+		return "", false, fmt.Errorf("%w: format must be json, logfmt, syslog, clf or auto, got %q", errInvalidParameter, value)
+	}
+}
+
+// filenamesFromQuery returns the files a request asks for, resolved against the configured roots
+// filename can be repeated and can be a glob pattern (nginx/*.log)
+// multiple is true when the response should interleave lines of several files, even if the glob matched only one.
+// more files than max_size.glob are rejected, every one of them is opened for the request
+func filenamesFromQuery(c *gin.Context) (filenames []string, multiple bool, err error) {
+	config := currentConfig()
+	patterns := c.QueryArray("filename")
+	if len(patterns) == 0 {
+		patterns = []string{config.DefaultFilename}
+	}
+
+	for _, pattern := range patterns {
+		if !hasGlobMeta(pattern) {
+			path, err := config.Roots.Resolve(pattern)
+			if err != nil {
+				auditRejectedPath(c, pattern, err)
+				return nil, false, err
+			}
+			filenames = append(filenames, path)
+			continue
+		}
+
+		multiple = true
+		matches, err := config.Roots.Glob(pattern)
+		if errors.Is(err, filepath.ErrBadPattern) {
+			return nil, false, fmt.Errorf("%w: filename: %v", errInvalidParameter, err)
+		}
+		if err != nil {
+			auditRejectedPath(c, pattern, err)
+			return nil, false, err
+		}
+		filenames = append(filenames, matches...)
+	}
+
+	if len(filenames) == 0 {
+		return nil, false, fmt.Errorf("%w: nothing matches %v", file.ErrFileNotFound, patterns)
+	}
+	if maximum := config.maxSize("glob"); len(filenames) > maximum {
+		return nil, false, fmt.Errorf("%w: filename matches %d files, at most %d can be queried at once", errInvalidParameter, len(filenames), maximum)
+	}
+
+	return filenames, multiple || len(patterns) > 1, nil
+}
+
+func hasGlobMeta(pattern string) bool {
+	for _, char := range pattern {
+		switch char {
+		case '*', '?', '[', '\\':
+			return true
+		}
+	}
+	return false
+}
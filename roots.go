@@ -0,0 +1,29 @@
+package main
+
+import (
+	"cribl/logmonitor/file"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+)
+
+// auditRejectedPath logs requests for files outside of the roots or denied by them
+func auditRejectedPath(c *gin.Context, name string, err error) {
+	if errors.Is(err, file.ErrPathNotAllowed) {
+		log.Printf("audit: rejected filename %q from %s (%s %s): %v",
+			name, c.ClientIP(), c.Request.Method, c.Request.URL.Path, err)
+	}
+}
+
+// resolveFilename turns the filename query param into the path of the file to read
+func resolveFilename(c *gin.Context) (string, error) {
+	name := c.DefaultQuery("filename", currentConfig().DefaultFilename)
+
+	path, err := currentConfig().Roots.Resolve(name)
+	if err != nil {
+		auditRejectedPath(c, name, err)
+		return "", err
+	}
+
+	return path, nil
+}
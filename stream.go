@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"cribl/logmonitor/file"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"net/http"
+)
+
+var upgrader = websocket.Upgrader{
+	// same as the Access-Control-Allow-Origin: * on the other endpoints
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// streamLogs sends the last size lines matching the filter and then keeps pushing new lines as they are appended.
+// plain requests get Server-Sent Events, requests asking for a websocket upgrade get a text message per line
+func streamLogs(c *gin.Context) {
+	numOfEntries, err := sizeFromQuery(c, "size", 10, "stream")
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	matcher, _, err := matcherFromQuery(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	filenameWithPath, err := resolveFilename(c)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// remember where the file ends before reading the last lines,
+	// following the file from here on won't miss anything appended in the meantime
+	identity, err := file.GetFileIdentity(filenameWithPath)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// a last line without line break is still being written, the follower sends it once it's complete
+	end, err := file.CompleteLinesEnd(filenameWithPath, identity.Size)
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// pin the end of the file to where we'll start following it
+	lastLines, _, err := file.ReadPage(filenameWithPath, numOfEntries, matcher, &file.Cursor{
+		Offset: identity.Size - end,
+		Device: identity.Device,
+		Inode:  identity.Inode,
+		Size:   identity.Size,
+	})
+	if err != nil {
+		abortWithError(c, err)
+		return
+	}
+
+	// tail -f prints the oldest line first
+	backlog := make([]string, len(lastLines))
+	for i, line := range lastLines {
+		backlog[len(lastLines)-1-i] = line
+	}
+
+	follow := func(ctx context.Context, onLine func(line string) error) error {
+		return file.FollowFile(ctx, filenameWithPath, end, matcher, onLine)
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		streamWebSocket(c, backlog, follow)
+		return
+	}
+
+	streamSSE(c, backlog, follow)
+}
+
+func streamSSE(c *gin.Context, backlog []string, follow func(context.Context, func(string) error) error) {
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers responses by default which defeats the purpose
+	c.Writer.Header().Set("X-Accel-Buffering", "no")
+
+	for _, line := range backlog {
+		c.SSEvent("line", line)
+	}
+	c.Writer.Flush()
+
+	ctx := c.Request.Context()
+	err := follow(ctx, func(line string) error {
+		c.SSEvent("line", line)
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	if err != nil && ctx.Err() == nil {
+		c.Error(err)
+		c.SSEvent("error", err.Error())
+		c.Writer.Flush()
+	}
+}
+
+func streamWebSocket(c *gin.Context, backlog []string, follow func(context.Context, func(string) error) error) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already replied with an error status
+		c.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// we don't expect messages from the client, but we need to read to notice it going away
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(line string) error {
+		return conn.WriteMessage(websocket.TextMessage, []byte(line))
+	}
+
+	for _, line := range backlog {
+		if err := send(line); err != nil {
+			return
+		}
+	}
+
+	if err := follow(ctx, send); err != nil && ctx.Err() == nil {
+		c.Error(err)
+		conn.WriteMessage(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error()))
+	}
+}